@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"bitbucket.org/stop-panic/signaling/internal/admin"
+	"bitbucket.org/stop-panic/signaling/internal/api"
 	"bitbucket.org/stop-panic/signaling/internal/config"
 	"bitbucket.org/stop-panic/signaling/internal/handler"
+	"bitbucket.org/stop-panic/signaling/internal/sdp"
+	"bitbucket.org/stop-panic/signaling/internal/stun"
+	"bitbucket.org/stop-panic/signaling/internal/tracing"
 	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -28,6 +38,11 @@ func main() {
 	setLoggingFormat(conf.Logs.Format)
 	setLoggingLevel(conf.Logs.Level)
 
+	shutdownTracing, err := tracing.Setup(conf.Tracing.Exporter, conf.Tracing.Endpoint)
+	if err != nil {
+		log.WithError(err).Fatal("error while setting up tracing")
+	}
+
 	upgrader := &websocket.Upgrader{
 		HandshakeTimeout:  5 * time.Second,
 		ReadBufferSize:    1024,
@@ -37,10 +52,80 @@ func main() {
 		EnableCompression: true,
 	}
 
-	server := handler.NewServer(upgrader)
+	stunUrls := conf.Ice.StunUrls
+	if conf.Stun.Addr != "" {
+		go runStunServer(conf.Stun.Addr)
+
+		if conf.Stun.Url != "" {
+			stunUrls = append([]string{conf.Stun.Url}, stunUrls...)
+		}
+	}
 
+	apiClient := api.NewClient(conf.Api.Url, conf.Api.EscalationUrl)
+	server := handler.NewServer(upgrader, apiClient, handler.Options{
+		SubjectHeader: conf.Server.SubjectHeader,
+		Supervisors:   conf.Server.Supervisors,
+		RateLimits: handler.RateLimits{
+			UpgradeRate:    conf.Limits.UpgradeRate,
+			UpgradeBurst:   conf.Limits.UpgradeBurst,
+			CallRate:       conf.Limits.CallRate,
+			CallBurst:      conf.Limits.CallBurst,
+			SignalingRate:  conf.Limits.SignalingRate,
+			SignalingBurst: conf.Limits.SignalingBurst,
+			AbuseThreshold: conf.Limits.AbuseThreshold,
+		},
+		MessageSizes: handler.MessageSizeLimits{
+			MaxMessageSize: int64(conf.Limits.MaxMessageSize),
+			Signaling:      conf.Limits.MaxSignalingSize,
+			Call:           conf.Limits.MaxCallSize,
+			Answer:         conf.Limits.MaxAnswerSize,
+			Control:        conf.Limits.MaxControlSize,
+		},
+		Capacity: handler.CapacityLimits{
+			MaxConnections: conf.Capacity.MaxConnections,
+			MaxPairs:       conf.Capacity.MaxPairs,
+			HighWatermark:  conf.Capacity.HighWatermark,
+			RetryAfter:     conf.Capacity.RetryAfter,
+		},
+		IceServers: handler.IceServers{
+			StunUrls:   stunUrls,
+			TurnUrls:   conf.Ice.TurnUrls,
+			TurnSecret: conf.Ice.TurnSecret,
+			TurnTTL:    conf.Ice.TurnTTL,
+		},
+		RelayOnly: conf.Privacy.RelayOnly,
+		SdpPolicy: sdpPolicy(&conf.Sdp),
+		Queue: handler.QueueOptions{
+			StatusInterval:  conf.Queue.StatusInterval,
+			MaxWait:         conf.Queue.MaxWait,
+			Fallback:        conf.Queue.FallbackMessage,
+			RelaxAfter:      conf.Queue.RelaxAfter,
+			OfferTimeout:    conf.Queue.OfferTimeout,
+			MaxOffers:       conf.Queue.MaxOffers,
+			WrapUp:          conf.Queue.WrapUp,
+			RingAll:         conf.Queue.RingAll,
+		},
+		HoldMessage: conf.Hold.Message,
+	})
 	sslEnable := isSslEnable(&conf.Server)
 
+	if conf.Admin.Addr != "" {
+		tlsErr := checkTls(&conf.Server, sslEnable)
+
+		liveness := []admin.Check{
+			{Name: "hub", Probe: server.Ping},
+		}
+		readiness := []admin.Check{
+			{Name: "draining", Probe: server.CheckDraining},
+			{Name: "api", Probe: apiClient.Ping},
+			{Name: "tls", Probe: func(_ context.Context) error { return tlsErr }},
+		}
+
+		go runAdminServer(conf.Admin.Addr, admin.NewServer(liveness, readiness, server, server, conf.Admin.Token))
+	}
+
+	go drainOnShutdown(server, conf.Server.DrainTimeout, shutdownTracing)
+
 	if sslEnable {
 		err = http.ListenAndServeTLS(conf.Server.Addr, conf.Server.TlsCert, conf.Server.TlsKey, server)
 	}
@@ -54,6 +139,53 @@ func main() {
 	}
 }
 
+// sdpPolicy returns the policy enforced on the signaling, nil disables the validation
+func sdpPolicy(conf *config.Sdp) *sdp.Policy {
+	if !conf.Validate {
+		return nil
+	}
+
+	return &sdp.Policy{
+		AllowedCodecs: conf.AllowedCodecs,
+		MaxBandwidth:  conf.MaxBandwidth,
+		AudioOnly:     conf.AudioOnly,
+	}
+}
+
+func runAdminServer(addr string, adminServer *admin.Server) {
+	if err := http.ListenAndServe(addr, adminServer); err != nil {
+		log.WithError(err).Error("error while starting an admin server")
+	}
+}
+
+func runStunServer(addr string) {
+	log.WithField("addr", addr).Info("starting an embedded STUN server")
+
+	if err := stun.NewServer(addr).ListenAndServe(); err != nil {
+		log.WithError(err).Error("error while starting a STUN server")
+	}
+}
+
+// drainOnShutdown stops accepting new connections on SIGINT or SIGTERM
+// and gives the established ones the drain timeout before exiting
+func drainOnShutdown(server *handler.Server, drainTimeout time.Duration, shutdownTracing func(context.Context) error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	log.WithField("signal", sig.String()).Infof("draining the server for %v", drainTimeout)
+	server.Drain()
+	time.Sleep(drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(ctx); err != nil {
+		log.WithError(err).Error("error while flushing the traces")
+	}
+	cancel()
+
+	os.Exit(0)
+}
+
 func setLoggingFormat(format string) {
 	switch format {
 	case loggingFormatJson:
@@ -85,6 +217,23 @@ func isSslEnable(conf *config.Server) bool {
 	return isFileExists(conf.TlsCert) && isFileExists(conf.TlsKey)
 }
 
+// checkTls reports an error if TLS is configured but the certificate couldn't be loaded
+func checkTls(conf *config.Server, sslEnable bool) error {
+	if conf.TlsCert == "" && conf.TlsKey == "" {
+		return nil
+	}
+
+	if !sslEnable {
+		return errors.New("the tls certificate or key file doesn't exist")
+	}
+
+	if _, err := tls.LoadX509KeyPair(conf.TlsCert, conf.TlsKey); err != nil {
+		return errors.Wrap(err, "couldn't load the tls certificate")
+	}
+
+	return nil
+}
+
 func isFileExists(filepath string) bool {
 	info, err := os.Stat(filepath)
 	if os.IsNotExist(err) {
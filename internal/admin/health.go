@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/problem"
+	response "github.com/gromson/http-json-response"
+)
+
+const (
+	checkTimeout = 3 * time.Second
+
+	checkStatusOk    = "ok"
+	checkStatusError = "error"
+)
+
+// Check is a named probe reporting an error if the checked subsystem is not healthy
+type Check struct {
+	Name  string
+	Probe func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func newHealthHandler(checks []Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		report := runChecks(ctx, checks)
+
+		if report.Status != checkStatusOk {
+			problem.NewServiceUnavailable(report).Respond(w)
+			return
+		}
+
+		response.NewSuccessResponse(report).Respond(w)
+	}
+}
+
+func runChecks(ctx context.Context, checks []Check) *healthReport {
+	report := &healthReport{
+		Status: checkStatusOk,
+		Checks: make(map[string]checkResult, len(checks)),
+	}
+
+	for _, check := range checks {
+		if err := check.Probe(ctx); err != nil {
+			report.Status = checkStatusError
+			report.Checks[check.Name] = checkResult{Status: checkStatusError, Error: err.Error()}
+			continue
+		}
+
+		report.Checks[check.Name] = checkResult{Status: checkStatusOk}
+	}
+
+	return report
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestHealthHandler_all_checks_pass(t *testing.T) {
+	checks := []Check{
+		{Name: "first", Probe: func(_ context.Context) error { return nil }},
+		{Name: "second", Probe: func(_ context.Context) error { return nil }},
+	}
+
+	rec := httptest.NewRecorder()
+	newHealthHandler(checks).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d expected, got %d", http.StatusOK, rec.Code)
+	}
+
+	var report healthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("couldn't decode the report: %s", err)
+	}
+
+	if report.Status != checkStatusOk || len(report.Checks) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestHealthHandler_failed_check(t *testing.T) {
+	checks := []Check{
+		{Name: "ok", Probe: func(_ context.Context) error { return nil }},
+		{Name: "failed", Probe: func(_ context.Context) error { return errors.New("unreachable") }},
+	}
+
+	rec := httptest.NewRecorder()
+	newHealthHandler(checks).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d expected, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	var problem struct {
+		Details healthReport `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("couldn't decode the problem: %s", err)
+	}
+
+	if problem.Details.Checks["failed"].Error != "unreachable" {
+		t.Errorf("failed check error expected in the report, got %+v", problem.Details)
+	}
+
+	if problem.Details.Checks["ok"].Status != checkStatusOk {
+		t.Errorf("passed check expected in the report, got %+v", problem.Details)
+	}
+}
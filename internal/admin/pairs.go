@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/handler"
+	"github.com/google/uuid"
+	response "github.com/gromson/http-json-response"
+	"github.com/pkg/errors"
+)
+
+const (
+	pairsPath      = "/api/pairs"
+	requestTimeout = 5 * time.Second
+)
+
+// Pairs gives access to the pairs registered in the hub
+type Pairs interface {
+	Pairs(ctx context.Context) ([]*handler.PairInfo, error)
+	Pair(ctx context.Context, pairID uuid.UUID) (*handler.PairInfo, error)
+	TerminatePair(ctx context.Context, pairID uuid.UUID) error
+}
+
+type pairsHandler struct {
+	pairs Pairs
+}
+
+func (h *pairsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	rawPairID := strings.Trim(strings.TrimPrefix(r.URL.Path, pairsPath), "/")
+
+	if rawPairID == "" {
+		if r.Method != http.MethodGet {
+			newMethodNotAllowedResponse(w, http.MethodGet)
+			return
+		}
+
+		h.list(ctx, w)
+		return
+	}
+
+	pairID, err := uuid.Parse(rawPairID)
+	if err != nil {
+		response.NewProblemResponse("Invalid pair ID", err.Error()).Respond(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.show(ctx, w, pairID)
+	case http.MethodDelete:
+		h.terminate(ctx, w, pairID)
+	default:
+		newMethodNotAllowedResponse(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (h *pairsHandler) list(ctx context.Context, w http.ResponseWriter) {
+	pairs, err := h.pairs.Pairs(ctx)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	response.NewSuccessResponse(pairs).Respond(w)
+}
+
+func (h *pairsHandler) show(ctx context.Context, w http.ResponseWriter, pairID uuid.UUID) {
+	pair, err := h.pairs.Pair(ctx, pairID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	response.NewSuccessResponse(pair).Respond(w)
+}
+
+func (h *pairsHandler) terminate(ctx context.Context, w http.ResponseWriter, pairID uuid.UUID) {
+	if err := h.pairs.TerminatePair(ctx, pairID); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	if errors.Is(err, handler.ErrPairNotFound) {
+		response.NewNotFoundResponse(err.Error()).Respond(w)
+		return
+	}
+
+	response.NewInternalError().Respond(w)
+}
+
+func newMethodNotAllowedResponse(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+	p := response.NewProblemResponse("Method Not Allowed", nil)
+	p.Type = "https://tools.ietf.org/html/rfc7231#section-6.5.5"
+	p.Status = http.StatusMethodNotAllowed
+	p.Respond(w)
+}
+
+// withBearerToken rejects the requests not authorized with the given token
+func withBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			response.NewUnauthorizedResponse("a valid bearer token is required").Respond(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bitbucket.org/stop-panic/signaling/internal/handler"
+	"github.com/google/uuid"
+)
+
+const testToken = "secret"
+
+func TestPairsApi_requires_token(t *testing.T) {
+	server := NewServer(nil, nil, newPairsStub(), nil, testToken)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, pairsPath, nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d expected, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestPairsApi_requires_bearer_scheme(t *testing.T) {
+	server := NewServer(nil, nil, newPairsStub(), nil, testToken)
+
+	r := httptest.NewRequest(http.MethodGet, pairsPath, nil)
+	r.Header.Set("Authorization", testToken)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status %d expected for a token without the scheme, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestPairsApi_terminate(t *testing.T) {
+	pairs := newPairsStub()
+	pairID := uuid.New()
+	pairs.pairs[pairID] = &handler.PairInfo{ID: pairID}
+	server := NewServer(nil, nil, pairs, nil, testToken)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newAuthorizedRequest(http.MethodDelete, pairsPath+"/"+pairID.String()))
+
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status %d expected, got %d", http.StatusNoContent, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newAuthorizedRequest(http.MethodGet, pairsPath+"/"+pairID.String()))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d expected for a terminated pair, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func newAuthorizedRequest(method string, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+
+	return r
+}
+
+type pairsStub struct {
+	pairs map[uuid.UUID]*handler.PairInfo
+}
+
+func newPairsStub() *pairsStub {
+	return &pairsStub{pairs: make(map[uuid.UUID]*handler.PairInfo)}
+}
+
+func (s *pairsStub) Pairs(_ context.Context) ([]*handler.PairInfo, error) {
+	pairs := make([]*handler.PairInfo, 0, len(s.pairs))
+	for _, p := range s.pairs {
+		pairs = append(pairs, p)
+	}
+
+	return pairs, nil
+}
+
+func (s *pairsStub) Pair(_ context.Context, pairID uuid.UUID) (*handler.PairInfo, error) {
+	p, ok := s.pairs[pairID]
+	if !ok {
+		return nil, handler.ErrPairNotFound
+	}
+
+	return p, nil
+}
+
+func (s *pairsStub) TerminatePair(_ context.Context, pairID uuid.UUID) error {
+	if _, ok := s.pairs[pairID]; !ok {
+		return handler.ErrPairNotFound
+	}
+	delete(s.pairs, pairID)
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"bitbucket.org/stop-panic/signaling/internal/handler"
+	response "github.com/gromson/http-json-response"
+)
+
+const (
+	helpersPath   = "/api/helpers"
+	callbacksPath = "/api/callbacks"
+	abandonedPath = "/api/abandoned"
+)
+
+// Queue gives access to the helpers, the callback requests and the abandoned calls registered in the hub
+type Queue interface {
+	Helpers(ctx context.Context) ([]*handler.HelperInfo, error)
+	Callbacks(ctx context.Context) ([]*handler.Callback, error)
+	AbandonedCalls(ctx context.Context) ([]*handler.AbandonedCall, error)
+}
+
+type helpersHandler struct {
+	queue Queue
+}
+
+func (h *helpersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		newMethodNotAllowedResponse(w, http.MethodGet)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	helpers, err := h.queue.Helpers(ctx)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	response.NewSuccessResponse(helpers).Respond(w)
+}
+
+type callbacksHandler struct {
+	queue Queue
+}
+
+func (h *callbacksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		newMethodNotAllowedResponse(w, http.MethodGet)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	callbacks, err := h.queue.Callbacks(ctx)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	response.NewSuccessResponse(callbacks).Respond(w)
+}
+
+type abandonedHandler struct {
+	queue Queue
+}
+
+func (h *abandonedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		newMethodNotAllowedResponse(w, http.MethodGet)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	abandoned, err := h.queue.AbandonedCalls(ctx)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	response.NewSuccessResponse(abandoned).Respond(w)
+}
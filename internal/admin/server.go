@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"net/http"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+)
+
+// Server serves the administrative endpoints which shouldn't be exposed to the clients
+type Server struct {
+	mux *http.ServeMux
+}
+
+// NewServer returns a pointer to a newly created Server instance serving metrics
+// and the liveness and readiness probes built from the given checks.
+// The pairs and queue APIs are served only if the token authorizing their requests is not empty
+func NewServer(liveness []Check, readiness []Check, pairs Pairs, queue Queue, token string) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", newHealthHandler(liveness))
+	mux.Handle("/readyz", newHealthHandler(readiness))
+
+	if token != "" {
+		pairsApi := withBearerToken(token, &pairsHandler{pairs: pairs})
+		mux.Handle(pairsPath, pairsApi)
+		mux.Handle(pairsPath+"/", pairsApi)
+		mux.Handle(helpersPath, withBearerToken(token, &helpersHandler{queue: queue}))
+		mux.Handle(callbacksPath, withBearerToken(token, &callbacksHandler{queue: queue}))
+		mux.Handle(abandonedPath, withBearerToken(token, &abandonedHandler{queue: queue}))
+	}
+
+	return &Server{mux: mux}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
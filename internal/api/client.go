@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const requestTimeout = 10 * time.Second
+
+// Client notifies the API about the calls initialized through the signaling server
+type Client struct {
+	url string
+	// escalationUrl receives the escalated calls, the escalations aren't sent if it's empty
+	escalationUrl string
+	httpClient    *http.Client
+}
+
+// NewClient returns a pointer to a newly created Client instance sending the call requests to the given url
+// and the escalations to the escalation url
+func NewClient(url string, escalationUrl string) *Client {
+	return &Client{
+		url:           url,
+		escalationUrl: escalationUrl,
+		httpClient:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// CallRequest describes a call waiting for the callee in the pair with a given ID
+type CallRequest struct {
+	PairID uuid.UUID `json:"pair_id"`
+	// Callee is the ID of the called user if the caller has addressed the call to a user
+	Callee string `json:"callee,omitempty"`
+	// Media is either audio or video
+	Media       string `json:"media"`
+	DisplayName string `json:"display_name,omitempty"`
+	// Custom is an arbitrary JSON value passed by the caller
+	Custom json.RawMessage `json:"custom,omitempty"`
+	// Priority is either normal, urgent or emergency
+	Priority string `json:"priority"`
+}
+
+// EscalationRequest describes a queued call none of the helpers has accepted, the call is offered to the supervisors
+type EscalationRequest struct {
+	PairID uuid.UUID `json:"pair_id"`
+	// Offers is the number of the helpers the call has been offered to
+	Offers       int             `json:"offers"`
+	Requirements []string        `json:"requirements,omitempty"`
+	Media        string          `json:"media"`
+	DisplayName  string          `json:"display_name,omitempty"`
+	Custom       json.RawMessage `json:"custom,omitempty"`
+	Priority     string          `json:"priority"`
+}
+
+// Call asks the API to notify the callee about a call, the trace context is propagated to the API through the request headers
+func (c *Client) Call(ctx context.Context, call *CallRequest) error {
+	return c.post(ctx, c.url, call)
+}
+
+// Escalate notifies the API about an escalated call
+func (c *Client) Escalate(ctx context.Context, escalation *EscalationRequest) error {
+	if c.escalationUrl == "" {
+		return nil
+	}
+
+	return c.post(ctx, c.escalationUrl, escalation)
+}
+
+func (c *Client) post(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode a request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "couldn't create a request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error while sending a request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("unexpected status code received from the API: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Ping checks that the API is reachable, any HTTP response is considered as a success
+func (c *Client) Ping(ctx context.Context) error {
+	if c.url == "" {
+		return errors.New("the API url isn't configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create a ping request")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "the API is unreachable")
+	}
+
+	return resp.Body.Close()
+}
@@ -3,20 +3,75 @@ package config
 import (
 	"flag"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/ini.v1"
 )
 
 const (
-	envAddr          = "STOP_PANIC_ADDR"
-	envTlsCert       = "STOP_PANIC_TLS_CERT"
-	envTlsKey        = "STOP_PANIC_TLS_KEY"
-	envAllowedOrigin = "STOP_PANIC_ALLOWED_ORIGIN"
-	envLogsLevel     = "STOP_PANIC_LOGS_LEVEL"
-	envLogsFormat    = "STOP_PANIC_LOGS_FORMAT"
-	envAppleCert     = "STOP_PANIC_APPLE_CERT"
-	envAppleBundle   = "STOP_PANIC_APPLE_BUNDLE"
+	envAddr             = "STOP_PANIC_ADDR"
+	envDrainTimeout     = "STOP_PANIC_DRAIN_TIMEOUT"
+	envTlsCert          = "STOP_PANIC_TLS_CERT"
+	envTlsKey           = "STOP_PANIC_TLS_KEY"
+	envAllowedOrigin    = "STOP_PANIC_ALLOWED_ORIGIN"
+	envSubjectHeader    = "STOP_PANIC_SUBJECT_HEADER"
+	envSupervisors      = "STOP_PANIC_SUPERVISORS"
+	envLogsLevel        = "STOP_PANIC_LOGS_LEVEL"
+	envLogsFormat       = "STOP_PANIC_LOGS_FORMAT"
+	envAppleCert        = "STOP_PANIC_APPLE_CERT"
+	envAppleBundle      = "STOP_PANIC_APPLE_BUNDLE"
+	envAdminAddr        = "STOP_PANIC_ADMIN_ADDR"
+	envAdminToken       = "STOP_PANIC_ADMIN_TOKEN"
+	envApiUrl           = "STOP_PANIC_API_URL"
+	envApiEscalationUrl = "STOP_PANIC_API_ESCALATION_URL"
+	envTraceExporter    = "STOP_PANIC_TRACING_EXPORTER"
+	envTraceEndpoint    = "STOP_PANIC_TRACING_ENDPOINT"
+
+	envLimitsUpgradeRate    = "STOP_PANIC_LIMITS_UPGRADE_RATE"
+	envLimitsUpgradeBurst   = "STOP_PANIC_LIMITS_UPGRADE_BURST"
+	envLimitsCallRate       = "STOP_PANIC_LIMITS_CALL_RATE"
+	envLimitsCallBurst      = "STOP_PANIC_LIMITS_CALL_BURST"
+	envLimitsSignalingRate  = "STOP_PANIC_LIMITS_SIGNALING_RATE"
+	envLimitsSignalingBurst = "STOP_PANIC_LIMITS_SIGNALING_BURST"
+	envLimitsAbuseThreshold = "STOP_PANIC_LIMITS_ABUSE_THRESHOLD"
+
+	envLimitsMaxMessageSize   = "STOP_PANIC_LIMITS_MAX_MESSAGE_SIZE"
+	envLimitsMaxSignalingSize = "STOP_PANIC_LIMITS_MAX_SIGNALING_SIZE"
+	envLimitsMaxCallSize      = "STOP_PANIC_LIMITS_MAX_CALL_SIZE"
+	envLimitsMaxAnswerSize    = "STOP_PANIC_LIMITS_MAX_ANSWER_SIZE"
+	envLimitsMaxControlSize   = "STOP_PANIC_LIMITS_MAX_CONTROL_SIZE"
+
+	envCapacityMaxConnections = "STOP_PANIC_CAPACITY_MAX_CONNECTIONS"
+	envCapacityMaxPairs       = "STOP_PANIC_CAPACITY_MAX_PAIRS"
+	envCapacityHighWatermark  = "STOP_PANIC_CAPACITY_HIGH_WATERMARK"
+	envCapacityRetryAfter     = "STOP_PANIC_CAPACITY_RETRY_AFTER"
+
+	envIceStunUrls   = "STOP_PANIC_ICE_STUN_URLS"
+	envIceTurnUrls   = "STOP_PANIC_ICE_TURN_URLS"
+	envIceTurnSecret = "STOP_PANIC_ICE_TURN_SECRET"
+	envIceTurnTTL    = "STOP_PANIC_ICE_TURN_TTL"
+
+	envStunAddr = "STOP_PANIC_STUN_ADDR"
+	envStunUrl  = "STOP_PANIC_STUN_URL"
+
+	envPrivacyRelayOnly = "STOP_PANIC_PRIVACY_RELAY_ONLY"
+
+	envSdpValidate      = "STOP_PANIC_SDP_VALIDATE"
+	envSdpAllowedCodecs = "STOP_PANIC_SDP_ALLOWED_CODECS"
+	envSdpMaxBandwidth  = "STOP_PANIC_SDP_MAX_BANDWIDTH"
+	envSdpAudioOnly     = "STOP_PANIC_SDP_AUDIO_ONLY"
+
+	envQueueStatusInterval  = "STOP_PANIC_QUEUE_STATUS_INTERVAL"
+	envQueueMaxWait         = "STOP_PANIC_QUEUE_MAX_WAIT"
+	envQueueFallbackMessage = "STOP_PANIC_QUEUE_FALLBACK_MESSAGE"
+	envQueueRelaxAfter      = "STOP_PANIC_QUEUE_RELAX_AFTER"
+	envQueueOfferTimeout    = "STOP_PANIC_QUEUE_OFFER_TIMEOUT"
+	envQueueMaxOffers       = "STOP_PANIC_QUEUE_MAX_OFFERS"
+	envQueueWrapUp          = "STOP_PANIC_QUEUE_WRAP_UP"
+	envQueueRingAll         = "STOP_PANIC_QUEUE_RING_ALL"
+
+	envHoldMessage = "STOP_PANIC_HOLD_MESSAGE"
 )
 
 var (
@@ -26,6 +81,8 @@ var (
 	allowedOrigin   string
 	loggingLevel    string
 	loggingFormat   string
+	adminAddr       string
+	apiUrl          string
 )
 
 func init() {
@@ -37,13 +94,26 @@ func init() {
 	flag.StringVar(&allowedOrigin, "allowed-origin", "*", "origin that allowed to connect to the server")
 	flag.StringVar(&loggingLevel, "logging-level", "info", "logging level")
 	flag.StringVar(&loggingFormat, "logging-format", "json", "logging format (options: json, text)")
+	flag.StringVar(&adminAddr, "admin-addr", "", "admin http service address, the admin server is disabled if empty")
+	flag.StringVar(&apiUrl, "api-url", "", "url of the API notified about the calls")
 	flag.Parse()
 }
 
 type Config struct {
-	Server Server
-	Logs   Logs
-	Apple  Apple
+	Server   Server
+	Logs     Logs
+	Apple    Apple
+	Admin    Admin
+	Api      Api
+	Tracing  Tracing
+	Limits   Limits
+	Capacity Capacity
+	Ice      Ice
+	Stun     Stun
+	Privacy  Privacy
+	Sdp      Sdp
+	Queue    Queue
+	Hold     Hold
 }
 
 type Server struct {
@@ -51,6 +121,12 @@ type Server struct {
 	TlsCert       string
 	TlsKey        string
 	AllowedOrigin string
+	// SubjectHeader is set by a trusted proxy with the authenticated user, the anonymous clients can't register
+	// as helpers, register their presence nor request a callback
+	SubjectHeader string
+	// Supervisors are the authenticated subjects allowed to monitor the calls and barge in
+	Supervisors  []string
+	DrainTimeout time.Duration
 }
 
 type Logs struct {
@@ -63,8 +139,113 @@ type Apple struct {
 	Bundle string
 }
 
+type Admin struct {
+	Addr  string
+	Token string
+}
+
+type Api struct {
+	Url string
+	// EscalationUrl is notified about the escalated calls, the escalations aren't sent if it's empty
+	EscalationUrl string
+}
+
+// Limits are the token bucket rate limits, the rates are per second and a zero rate disables a limit
+type Limits struct {
+	UpgradeRate    float64
+	UpgradeBurst   int
+	CallRate       float64
+	CallBurst      int
+	SignalingRate  float64
+	SignalingBurst int
+	AbuseThreshold int
+
+	// The sizes are in bytes, a zero size isn't limited
+	MaxMessageSize   int
+	MaxSignalingSize int
+	MaxCallSize      int
+	MaxAnswerSize    int
+	MaxControlSize   int
+}
+
+// Capacity is the maximum load of the server, a zero maximum is unlimited
+type Capacity struct {
+	MaxConnections int
+	MaxPairs       int
+	// HighWatermark is the fraction of a maximum which is logged when it's reached
+	HighWatermark float64
+	RetryAfter    time.Duration
+}
+
+// Ice is the STUN and TURN servers advertised to the clients
+type Ice struct {
+	StunUrls []string
+	TurnUrls []string
+	// TurnSecret is shared with the TURN server to issue the ephemeral credentials
+	TurnSecret string
+	TurnTTL    time.Duration
+}
+
+// Stun is the embedded STUN server, it's disabled if the address is empty
+type Stun struct {
+	Addr string
+	// Url is the address of the server advertised to the clients, e.g. stun:signaling.example.com:3478
+	Url string
+}
+
+type Privacy struct {
+	// RelayOnly strips the candidates revealing the addresses of the peers from the signaling of all the calls
+	RelayOnly bool
+}
+
+// Sdp is the policy enforced on the session descriptions of the signaling, the policy is applied only if Validate is set
+type Sdp struct {
+	Validate bool
+	// AllowedCodecs are the encoding names of the codecs, all the codecs are allowed if it's empty
+	AllowedCodecs []string
+	// MaxBandwidth is in kbps, a zero bandwidth isn't limited
+	MaxBandwidth int
+	AudioOnly    bool
+}
+
+// Queue configures the updates sent to the callers waiting for a helper
+type Queue struct {
+	// StatusInterval is the period of the queue status updates, the updates are disabled if it's zero
+	StatusInterval time.Duration
+	// MaxWait is the wait after which the fallback message is sent to a caller, the message isn't sent if it's zero
+	MaxWait         time.Duration
+	FallbackMessage string
+	// RelaxAfter is the wait after which the required skills of a call are relaxed, they're never relaxed if it's zero
+	RelaxAfter time.Duration
+	// OfferTimeout is the time a helper has to answer an offered call, the offers don't expire if it's zero
+	OfferTimeout time.Duration
+	// MaxOffers is the number of the declined or expired offers after which a call is escalated to the supervisors,
+	// the calls aren't escalated if it's zero
+	MaxOffers int
+	// WrapUp is the time the helpers spend in the wrap-up after a call before they're available again,
+	// the helpers are available right away if it's zero
+	WrapUp time.Duration
+	// RingAll offers the emergency calls to all the available helpers at once instead of queueing them
+	RingAll bool
+}
+
+// Hold configures the calls put on hold
+type Hold struct {
+	// Message is the on hold notification sent to the held party, e.g. a text shown while waiting
+	Message string
+}
+
+type Tracing struct {
+	// Exporter is either otlp or stdout, tracing is disabled if it's empty
+	Exporter string
+	Endpoint string
+}
+
 func GetConfig() (*Config, error) {
-	conf := createFromEnv()
+	conf, err := createFromEnv()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while reading environment variables")
+	}
 
 	if err := updateFromIni(conf); err != nil {
 		return nil, errors.Wrap(err, "error while reading ini config")
@@ -75,13 +256,14 @@ func GetConfig() (*Config, error) {
 	return conf, nil
 }
 
-func createFromEnv() *Config {
-	return &Config{
+func createFromEnv() (*Config, error) {
+	conf := &Config{
 		Server: Server{
 			Addr:          os.Getenv(envAddr),
 			TlsCert:       os.Getenv(envTlsCert),
 			TlsKey:        os.Getenv(envTlsKey),
 			AllowedOrigin: os.Getenv(envAllowedOrigin),
+			SubjectHeader: os.Getenv(envSubjectHeader),
 		},
 		Logs: Logs{
 			Level:  os.Getenv(envLogsLevel),
@@ -91,7 +273,134 @@ func createFromEnv() *Config {
 			Cert:   os.Getenv(envAppleCert),
 			Bundle: os.Getenv(envAppleBundle),
 		},
+		Admin: Admin{
+			Addr:  os.Getenv(envAdminAddr),
+			Token: os.Getenv(envAdminToken),
+		},
+		Api: Api{
+			Url:           os.Getenv(envApiUrl),
+			EscalationUrl: os.Getenv(envApiEscalationUrl),
+		},
+		Tracing: Tracing{
+			Exporter: os.Getenv(envTraceExporter),
+			Endpoint: os.Getenv(envTraceEndpoint),
+		},
+		Ice: Ice{
+			TurnSecret: os.Getenv(envIceTurnSecret),
+		},
+		Stun: Stun{
+			Addr: os.Getenv(envStunAddr),
+			Url:  os.Getenv(envStunUrl),
+		},
+		Queue: Queue{
+			FallbackMessage: os.Getenv(envQueueFallbackMessage),
+		},
+		Hold: Hold{
+			Message: os.Getenv(envHoldMessage),
+		},
 	}
+
+	setListFromEnv(envSupervisors, &conf.Server.Supervisors)
+	setListFromEnv(envIceStunUrls, &conf.Ice.StunUrls)
+	setListFromEnv(envIceTurnUrls, &conf.Ice.TurnUrls)
+	setListFromEnv(envSdpAllowedCodecs, &conf.Sdp.AllowedCodecs)
+
+	if err := updateNumbersFromEnv(conf); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+func updateNumbersFromEnv(conf *Config) error {
+	if err := setDurationFromEnv(envDrainTimeout, &conf.Server.DrainTimeout); err != nil {
+		return err
+	}
+
+	if err := setDurationFromEnv(envCapacityRetryAfter, &conf.Capacity.RetryAfter); err != nil {
+		return err
+	}
+
+	if err := setDurationFromEnv(envIceTurnTTL, &conf.Ice.TurnTTL); err != nil {
+		return err
+	}
+
+	if err := setDurationFromEnv(envQueueStatusInterval, &conf.Queue.StatusInterval); err != nil {
+		return err
+	}
+
+	if err := setDurationFromEnv(envQueueMaxWait, &conf.Queue.MaxWait); err != nil {
+		return err
+	}
+
+	if err := setDurationFromEnv(envQueueRelaxAfter, &conf.Queue.RelaxAfter); err != nil {
+		return err
+	}
+
+	if err := setDurationFromEnv(envQueueOfferTimeout, &conf.Queue.OfferTimeout); err != nil {
+		return err
+	}
+
+	if err := setDurationFromEnv(envQueueWrapUp, &conf.Queue.WrapUp); err != nil {
+		return err
+	}
+
+	if err := setBoolFromEnv(envPrivacyRelayOnly, &conf.Privacy.RelayOnly); err != nil {
+		return err
+	}
+
+	if err := setBoolFromEnv(envQueueRingAll, &conf.Queue.RingAll); err != nil {
+		return err
+	}
+
+	if err := setBoolFromEnv(envSdpValidate, &conf.Sdp.Validate); err != nil {
+		return err
+	}
+
+	if err := setBoolFromEnv(envSdpAudioOnly, &conf.Sdp.AudioOnly); err != nil {
+		return err
+	}
+
+	limits := []struct {
+		key string
+		dst *float64
+	}{
+		{envLimitsUpgradeRate, &conf.Limits.UpgradeRate},
+		{envLimitsCallRate, &conf.Limits.CallRate},
+		{envLimitsSignalingRate, &conf.Limits.SignalingRate},
+		{envCapacityHighWatermark, &conf.Capacity.HighWatermark},
+	}
+	for _, l := range limits {
+		if err := setFloatFromEnv(l.key, l.dst); err != nil {
+			return err
+		}
+	}
+
+	integers := []struct {
+		key string
+		dst *int
+	}{
+		{envLimitsUpgradeBurst, &conf.Limits.UpgradeBurst},
+		{envLimitsCallBurst, &conf.Limits.CallBurst},
+		{envLimitsSignalingBurst, &conf.Limits.SignalingBurst},
+		{envLimitsAbuseThreshold, &conf.Limits.AbuseThreshold},
+		{envLimitsMaxMessageSize, &conf.Limits.MaxMessageSize},
+		{envLimitsMaxSignalingSize, &conf.Limits.MaxSignalingSize},
+		{envLimitsMaxCallSize, &conf.Limits.MaxCallSize},
+		{envLimitsMaxAnswerSize, &conf.Limits.MaxAnswerSize},
+		{envLimitsMaxControlSize, &conf.Limits.MaxControlSize},
+		{envCapacityMaxConnections, &conf.Capacity.MaxConnections},
+		{envCapacityMaxPairs, &conf.Capacity.MaxPairs},
+		{envSdpMaxBandwidth, &conf.Sdp.MaxBandwidth},
+		{envQueueMaxOffers, &conf.Queue.MaxOffers},
+	}
+	for _, i := range integers {
+		if err := setIntFromEnv(i.key, i.dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func updateFromIni(conf *Config) error {
@@ -124,6 +433,17 @@ func updateFromIni(conf *Config) error {
 		conf.Server.AllowedOrigin = allowedOriginIni
 	}
 
+	subjectHeaderIni := confIni.Section("server").Key("subject_header").String()
+	if subjectHeaderIni != "" {
+		conf.Server.SubjectHeader = subjectHeaderIni
+	}
+
+	setListFromIni(confIni.Section("server"), "supervisors", &conf.Server.Supervisors)
+
+	if err := setDurationFromIni(confIni.Section("server"), "drain_timeout", &conf.Server.DrainTimeout); err != nil {
+		return err
+	}
+
 	logsLevelIni := confIni.Section("logs").Key("level").String()
 	if logsLevelIni != "" {
 		conf.Logs.Level = logsLevelIni
@@ -134,9 +454,135 @@ func updateFromIni(conf *Config) error {
 		conf.Logs.Level = logsFormatIni
 	}
 
+	adminAddrIni := confIni.Section("admin").Key("addr").String()
+	if adminAddrIni != "" {
+		conf.Admin.Addr = adminAddrIni
+	}
+
+	adminTokenIni := confIni.Section("admin").Key("token").String()
+	if adminTokenIni != "" {
+		conf.Admin.Token = adminTokenIni
+	}
+
+	apiUrlIni := confIni.Section("api").Key("url").String()
+	if apiUrlIni != "" {
+		conf.Api.Url = apiUrlIni
+	}
+
+	apiEscalationUrlIni := confIni.Section("api").Key("escalation_url").String()
+	if apiEscalationUrlIni != "" {
+		conf.Api.EscalationUrl = apiEscalationUrlIni
+	}
+
+	tracingExporterIni := confIni.Section("tracing").Key("exporter").String()
+	if tracingExporterIni != "" {
+		conf.Tracing.Exporter = tracingExporterIni
+	}
+
+	tracingEndpointIni := confIni.Section("tracing").Key("endpoint").String()
+	if tracingEndpointIni != "" {
+		conf.Tracing.Endpoint = tracingEndpointIni
+	}
+
+	if err := updateLimitsFromIni(confIni.Section("limits"), &conf.Limits); err != nil {
+		return err
+	}
+
+	if err := updateCapacityFromIni(confIni.Section("capacity"), &conf.Capacity); err != nil {
+		return err
+	}
+
+	iceSection := confIni.Section("ice")
+	setListFromIni(iceSection, "stun_urls", &conf.Ice.StunUrls)
+	setListFromIni(iceSection, "turn_urls", &conf.Ice.TurnUrls)
+
+	turnSecretIni := iceSection.Key("turn_secret").String()
+	if turnSecretIni != "" {
+		conf.Ice.TurnSecret = turnSecretIni
+	}
+
+	if err := setDurationFromIni(iceSection, "turn_ttl", &conf.Ice.TurnTTL); err != nil {
+		return err
+	}
+
+	stunAddrIni := confIni.Section("stun").Key("addr").String()
+	if stunAddrIni != "" {
+		conf.Stun.Addr = stunAddrIni
+	}
+
+	stunUrlIni := confIni.Section("stun").Key("url").String()
+	if stunUrlIni != "" {
+		conf.Stun.Url = stunUrlIni
+	}
+
+	if err := setBoolFromIni(confIni.Section("privacy"), "relay_only", &conf.Privacy.RelayOnly); err != nil {
+		return err
+	}
+
+	if err := updateSdpFromIni(confIni.Section("sdp"), &conf.Sdp); err != nil {
+		return err
+	}
+
+	if err := updateQueueFromIni(confIni.Section("queue"), &conf.Queue); err != nil {
+		return err
+	}
+
+	holdMessageIni := confIni.Section("hold").Key("message").String()
+	if holdMessageIni != "" {
+		conf.Hold.Message = holdMessageIni
+	}
+
+	return nil
+}
+
+func updateLimitsFromIni(section *ini.Section, limits *Limits) error {
+	rates := map[string]*float64{
+		"upgrade_rate":   &limits.UpgradeRate,
+		"call_rate":      &limits.CallRate,
+		"signaling_rate": &limits.SignalingRate,
+	}
+	for key, dst := range rates {
+		if err := setFloatFromIni(section, key, dst); err != nil {
+			return err
+		}
+	}
+
+	integers := map[string]*int{
+		"upgrade_burst":      &limits.UpgradeBurst,
+		"call_burst":         &limits.CallBurst,
+		"signaling_burst":    &limits.SignalingBurst,
+		"abuse_threshold":    &limits.AbuseThreshold,
+		"max_message_size":   &limits.MaxMessageSize,
+		"max_signaling_size": &limits.MaxSignalingSize,
+		"max_call_size":      &limits.MaxCallSize,
+		"max_answer_size":    &limits.MaxAnswerSize,
+		"max_control_size":   &limits.MaxControlSize,
+	}
+	for key, dst := range integers {
+		if err := setIntFromIni(section, key, dst); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func updateCapacityFromIni(section *ini.Section, capacity *Capacity) error {
+	if err := setIntFromIni(section, "max_connections", &capacity.MaxConnections); err != nil {
+		return err
+	}
+
+	if err := setIntFromIni(section, "max_pairs", &capacity.MaxPairs); err != nil {
+		return err
+	}
+
+	if err := setFloatFromIni(section, "high_watermark", &capacity.HighWatermark); err != nil {
+		return err
+	}
+
+	return setDurationFromIni(section, "retry_after", &capacity.RetryAfter)
+}
+
 func updateFromFlags(conf *Config) {
 	if addr != "" {
 		conf.Server.Addr = addr
@@ -161,4 +607,63 @@ func updateFromFlags(conf *Config) {
 	if loggingFormat != "" {
 		conf.Logs.Format = loggingFormat
 	}
+
+	if adminAddr != "" {
+		conf.Admin.Addr = adminAddr
+	}
+
+	if apiUrl != "" {
+		conf.Api.Url = apiUrl
+	}
+}
+
+func updateSdpFromIni(section *ini.Section, sdp *Sdp) error {
+	if err := setBoolFromIni(section, "validate", &sdp.Validate); err != nil {
+		return err
+	}
+
+	if err := setBoolFromIni(section, "audio_only", &sdp.AudioOnly); err != nil {
+		return err
+	}
+
+	setListFromIni(section, "allowed_codecs", &sdp.AllowedCodecs)
+
+	return setIntFromIni(section, "max_bandwidth", &sdp.MaxBandwidth)
+}
+
+func updateQueueFromIni(section *ini.Section, queue *Queue) error {
+	if err := setDurationFromIni(section, "status_interval", &queue.StatusInterval); err != nil {
+		return err
+	}
+
+	if err := setDurationFromIni(section, "max_wait", &queue.MaxWait); err != nil {
+		return err
+	}
+
+	if err := setDurationFromIni(section, "relax_after", &queue.RelaxAfter); err != nil {
+		return err
+	}
+
+	if err := setDurationFromIni(section, "offer_timeout", &queue.OfferTimeout); err != nil {
+		return err
+	}
+
+	if err := setIntFromIni(section, "max_offers", &queue.MaxOffers); err != nil {
+		return err
+	}
+
+	if err := setDurationFromIni(section, "wrap_up", &queue.WrapUp); err != nil {
+		return err
+	}
+
+	if err := setBoolFromIni(section, "ring_all", &queue.RingAll); err != nil {
+		return err
+	}
+
+	fallbackMessageIni := section.Key("fallback_message").String()
+	if fallbackMessageIni != "" {
+		queue.FallbackMessage = fallbackMessageIni
+	}
+
+	return nil
 }
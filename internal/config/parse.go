@@ -0,0 +1,153 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/ini.v1"
+)
+
+// The setters below keep the destination untouched if the value is not set
+
+func setDurationFromEnv(key string, dst *time.Duration) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.Wrapf(err, "invalid duration in %s", key)
+	}
+	*dst = d
+
+	return nil
+}
+
+func setFloatFromEnv(key string, dst *float64) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid number in %s", key)
+	}
+	*dst = f
+
+	return nil
+}
+
+func setIntFromEnv(key string, dst *int) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return errors.Wrapf(err, "invalid integer in %s", key)
+	}
+	*dst = i
+
+	return nil
+}
+
+func setBoolFromEnv(key string, dst *bool) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return errors.Wrapf(err, "invalid boolean in %s", key)
+	}
+	*dst = b
+
+	return nil
+}
+
+func setListFromEnv(key string, dst *[]string) {
+	if list := splitList(os.Getenv(key)); len(list) > 0 {
+		*dst = list
+	}
+}
+
+func setListFromIni(section *ini.Section, key string, dst *[]string) {
+	if list := splitList(section.Key(key).String()); len(list) > 0 {
+		*dst = list
+	}
+}
+
+// splitList splits a comma separated list dropping the empty items
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func setDurationFromIni(section *ini.Section, key string, dst *time.Duration) error {
+	if section.Key(key).String() == "" {
+		return nil
+	}
+
+	d, err := section.Key(key).Duration()
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s.%s", section.Name(), key)
+	}
+	*dst = d
+
+	return nil
+}
+
+func setBoolFromIni(section *ini.Section, key string, dst *bool) error {
+	if section.Key(key).String() == "" {
+		return nil
+	}
+
+	b, err := section.Key(key).Bool()
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s.%s", section.Name(), key)
+	}
+	*dst = b
+
+	return nil
+}
+
+func setFloatFromIni(section *ini.Section, key string, dst *float64) error {
+	if section.Key(key).String() == "" {
+		return nil
+	}
+
+	f, err := section.Key(key).Float64()
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s.%s", section.Name(), key)
+	}
+	*dst = f
+
+	return nil
+}
+
+func setIntFromIni(section *ini.Section, key string, dst *int) error {
+	if section.Key(key).String() == "" {
+		return nil
+	}
+
+	i, err := section.Key(key).Int()
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s.%s", section.Name(), key)
+	}
+	*dst = i
+
+	return nil
+}
@@ -1,9 +1,19 @@
 package handler
 
-import "github.com/google/uuid"
+import (
+	"context"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/api"
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type ApiClient interface {
-	Call(pairID uuid.UUID) error
+	Call(ctx context.Context, call *api.CallRequest) error
+	Escalate(ctx context.Context, escalation *api.EscalationRequest) error
 }
 
 type ApiError struct {
@@ -12,4 +22,51 @@ type ApiError struct {
 
 func (e *ApiError) Error() string {
 	return e.err.Error()
-}
\ No newline at end of file
+}
+
+// callApi calls the API tracking the request latency and failures
+func callApi(ctx context.Context, apiClient ApiClient, p *pair) error {
+	ctx, span := tracer.Start(ctx, "ApiClient.Call")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("pair_id", p.id.String()),
+		attribute.String("media", p.call.Media),
+		attribute.String("priority", p.call.Priority),
+	)
+
+	start := time.Now()
+	err := apiClient.Call(ctx, &api.CallRequest{
+		PairID:      p.id,
+		Callee:      p.callee,
+		Media:       p.call.Media,
+		DisplayName: p.call.DisplayName,
+		Custom:      p.call.Custom,
+		Priority:    p.call.Priority,
+	})
+	observeApi(span, start, err)
+
+	return err
+}
+
+// escalateApi notifies the API about the escalated call tracking the request latency and failures
+func escalateApi(ctx context.Context, apiClient ApiClient, escalation *api.EscalationRequest) error {
+	ctx, span := tracer.Start(ctx, "ApiClient.Escalate")
+	defer span.End()
+	span.SetAttributes(attribute.String("pair_id", escalation.PairID.String()), attribute.Int("offers", escalation.Offers))
+
+	start := time.Now()
+	err := apiClient.Escalate(ctx, escalation)
+	observeApi(span, start, err)
+
+	return err
+}
+
+func observeApi(span trace.Span, start time.Time, err error) {
+	metrics.ApiCallDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.ApiCallFailures.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "the API call failed")
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"bitbucket.org/stop-panic/signaling/internal/sdp"
+	"github.com/pkg/errors"
+)
+
+// The media types of a call
+const (
+	mediaTypeAudio = "audio"
+	mediaTypeVideo = "video"
+)
+
+// The priorities of a call, the urgent calls are queued ahead of the normal ones and the emergency calls ahead of all
+const (
+	priorityNormal    = "normal"
+	priorityUrgent    = "urgent"
+	priorityEmergency = "emergency"
+)
+
+// priorityRanks order the queued calls by their priority
+var priorityRanks = map[string]int{
+	priorityNormal:    0,
+	priorityUrgent:    1,
+	priorityEmergency: 2,
+}
+
+const maxDisplayNameLength = 128
+
+// callMetadata describes a call to the callee, it's forwarded to the API and to the client answering the call
+type callMetadata struct {
+	// Media is either audio or video, the calls are video calls by default
+	Media       string `json:"media"`
+	DisplayName string `json:"display_name,omitempty"`
+	// Custom is an arbitrary JSON value passed by the caller to the callee untouched
+	Custom json.RawMessage `json:"custom,omitempty"`
+	// Priority is either normal, urgent or emergency, e.g. set by the caller app for the urgent calls
+	Priority string `json:"priority"`
+}
+
+// callRequest is the optional content of the call message
+type callRequest struct {
+	// Privacy requests the relay only connectivity hiding the addresses of the peers from each other
+	Privacy bool `json:"privacy"`
+	// Callee is the ID of the called user, the user is rung over their connection if they're online
+	Callee string `json:"callee,omitempty"`
+	// Helper puts the call into the queue of the calls distributed to the available helpers
+	Helper bool `json:"helper"`
+	// Requirements are the skill tags the helper taking the call should have, e.g. a language
+	Requirements []string `json:"requirements,omitempty"`
+	callMetadata
+}
+
+func parseCallRequest(content []byte) (*callRequest, error) {
+	call := &callRequest{callMetadata: callMetadata{Media: mediaTypeVideo, Priority: priorityNormal}}
+	if len(content) == 0 {
+		return call, nil
+	}
+
+	if err := json.Unmarshal(content, call); err != nil {
+		return nil, errors.Wrap(err, "invalid call request")
+	}
+
+	switch call.Media {
+	case "":
+		call.Media = mediaTypeVideo
+	case mediaTypeAudio, mediaTypeVideo:
+	default:
+		return nil, errors.Errorf("unknown call media type: '%s'", call.Media)
+	}
+
+	if call.Priority == "" {
+		call.Priority = priorityNormal
+	}
+
+	if _, ok := priorityRanks[call.Priority]; !ok {
+		return nil, errors.Errorf("unknown call priority: '%s'", call.Priority)
+	}
+
+	if call.Helper && call.Callee != "" {
+		return nil, errors.New("a call can't be addressed both to a callee and to a helper")
+	}
+
+	if len(call.Requirements) > 0 {
+		if !call.Helper {
+			return nil, errors.New("only the calls to a helper could have requirements")
+		}
+
+		requirements, err := parseSkills(call.Requirements)
+		if err != nil {
+			return nil, err
+		}
+		call.Requirements = requirements
+	}
+
+	if utf8.RuneCountInString(call.DisplayName) > maxDisplayNameLength {
+		return nil, errors.Errorf("the display name is longer than %d characters", maxDisplayNameLength)
+	}
+
+	return call, nil
+}
+
+// sdpPolicy returns the policy enforced on the signaling of the call, the video is rejected in the audio calls
+func (m *callMetadata) sdpPolicy(policy *sdp.Policy) *sdp.Policy {
+	if policy == nil || m.Media != mediaTypeAudio || policy.AudioOnly {
+		return policy
+	}
+
+	audioOnly := *policy
+	audioOnly.AudioOnly = true
+
+	return &audioOnly
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"testing"
+
+	"bitbucket.org/stop-panic/signaling/internal/sdp"
+)
+
+func TestParseCallRequest(t *testing.T) {
+	call, err := parseCallRequest(nil)
+	if err != nil || call.Media != mediaTypeVideo {
+		t.Errorf("a video call expected without the content, got %+v, %v", call, err)
+	}
+
+	call, err = parseCallRequest([]byte(`{"media":"audio","display_name":"Alice","custom":{"room":7}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if call.Media != mediaTypeAudio || call.DisplayName != "Alice" || string(call.Custom) != `{"room":7}` {
+		t.Errorf("unexpected call metadata: %+v", call.callMetadata)
+	}
+
+	if _, err := parseCallRequest([]byte(`{"media":"hologram"}`)); err == nil {
+		t.Error("an error expected for an unknown media type")
+	}
+
+	if call, err := parseCallRequest([]byte(`{"helper":true}`)); err != nil || call.Priority != priorityNormal {
+		t.Errorf("the normal priority expected by default, got %+v, %v", call, err)
+	}
+
+	if _, err := parseCallRequest([]byte(`{"priority":"asap"}`)); err == nil {
+		t.Error("an error expected for an unknown priority")
+	}
+}
+
+func TestCallMetadata_sdpPolicy(t *testing.T) {
+	policy := &sdp.Policy{MaxBandwidth: 64}
+	audio := callMetadata{Media: mediaTypeAudio}
+	video := callMetadata{Media: mediaTypeVideo}
+
+	if audio.sdpPolicy(nil) != nil {
+		t.Error("the signaling of an audio call isn't expected to be inspected without a policy")
+	}
+
+	if p := audio.sdpPolicy(policy); !p.AudioOnly || p.MaxBandwidth != 64 || policy.AudioOnly {
+		t.Errorf("an audio only copy of the policy expected, got %+v", p)
+	}
+
+	if video.sdpPolicy(policy) != policy {
+		t.Error("the policy of a video call expected to be kept")
+	}
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// The maximum number of the callback requests kept in the hub, the new requests are rejected once it's reached
+	maxCallbacks = 1000
+	// The maximum number of the abandoned calls kept in the hub, the oldest ones are dropped once it's reached
+	maxAbandoned = 1000
+)
+
+// Callback is the request of a queued caller to be called back by a helper instead of waiting,
+// the helper claiming it calls the user back either over their connection or through the API
+type Callback struct {
+	ID     uuid.UUID `json:"id"`
+	UserID string    `json:"user_id"`
+	// PairID is the ID of the call the callback has replaced, the pair is removed once the callback is registered
+	PairID      uuid.UUID `json:"pair_id"`
+	RequestedAt time.Time `json:"requested_at"`
+	// Waited is the time the caller has waited in the queue before requesting the callback
+	Waited       float64       `json:"waited_seconds"`
+	Requirements []string      `json:"requirements,omitempty"`
+	Call         *callMetadata `json:"call"`
+	priority     int
+}
+
+// AbandonedCall is a call its caller has hung up while it was waiting for a helper
+type AbandonedCall struct {
+	PairID      uuid.UUID `json:"pair_id"`
+	AbandonedAt time.Time `json:"abandoned_at"`
+	// Waited is the time the caller has waited for a helper before hanging up
+	Waited       float64       `json:"waited_seconds"`
+	Requirements []string      `json:"requirements,omitempty"`
+	Offers       int           `json:"offers"`
+	Call         *callMetadata `json:"call"`
+}
+
+// callbackRequest is sent to the hub by a queued caller asking to be called back
+type callbackRequest struct {
+	client *client
+	pairID uuid.UUID
+	userID string
+}
+
+// callbackClaim is sent to the hub by a helper claiming a given callback request, or the first one if the ID is nil
+type callbackClaim struct {
+	client     *client
+	callbackID uuid.UUID
+}
+
+// callbackRegisteredPayload is the content of the message telling the caller the callback has been registered
+type callbackRegisteredPayload struct {
+	PairID     uuid.UUID `json:"pair_id"`
+	CallbackID uuid.UUID `json:"callback_id"`
+}
+
+// parseCallbackUserID returns the ID of the user the caller is called back as, it's the subject of the connection
+func parseCallbackUserID(content []byte, subject string) (string, error) {
+	userID, err := parseUserID(content, subject)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid callback request")
+	}
+
+	return userID, nil
+}
+
+// registerCallback takes the call out of the queue and stores the request of its caller to be called back,
+// the callbacks are ordered by the priority of the calls like the queue, the pair is removed keeping the caller
+// connected, so the caller could be called back over the connection
+func (h *hub) registerCallback(request *callbackRequest) {
+	c := request.client
+
+	index := -1
+	for i, call := range h.queue {
+		if call.pair.id == request.pairID && call.caller == c && !call.transfer {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		h.failCallback(c, "Only the calls waiting in the queue could be called back")
+		return
+	}
+
+	if len(h.callbacks) >= maxCallbacks {
+		c.logger().Warn("couldn't register a callback, the maximum number of callbacks is reached")
+		h.failCallback(c, "Too many callback requests, try again later")
+		return
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't create a callback ID")
+		h.failCallback(c, "Couldn't register the callback")
+		return
+	}
+
+	call := h.queue[index]
+	h.queue = append(h.queue[:index], h.queue[index+1:]...)
+	h.updateQueueMetrics()
+
+	callback := &Callback{
+		ID:           id,
+		UserID:       request.userID,
+		PairID:       call.pair.id,
+		RequestedAt:  time.Now(),
+		Waited:       time.Since(call.enqueuedAt).Seconds(),
+		Requirements: call.requirements,
+		Call:         &call.pair.call,
+		priority:     call.priority,
+	}
+	h.insertCallback(callback)
+
+	// the call has already left the queue, so it isn't recorded as abandoned
+	call.pair.release(c)
+	h.removePair(call.pair, nil)
+
+	c.logger().WithFields(log.Fields{
+		"pair_id":     call.pair.id,
+		"callback_id": id,
+		"callback_to": request.userID,
+	}).Info("the caller has requested a callback")
+
+	content, err := json.Marshal(&callbackRegisteredPayload{PairID: call.pair.id, CallbackID: id})
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the callback registered payload")
+		return
+	}
+	c.post(connectionMessage{Typ: outgoingMessageCallbackRegistered, Content: content})
+}
+
+// insertCallback inserts the callback behind the callbacks having the same or a higher priority
+func (h *hub) insertCallback(callback *Callback) {
+	i := 0
+	for ; i < len(h.callbacks); i++ {
+		if h.callbacks[i].priority < callback.priority {
+			break
+		}
+	}
+
+	h.callbacks = append(h.callbacks, nil)
+	copy(h.callbacks[i+1:], h.callbacks[i:])
+	h.callbacks[i] = callback
+	metrics.PendingCallbacks.Set(float64(len(h.callbacks)))
+}
+
+// claimCallback hands the callback request over to the helper removing it from the hub
+func (h *hub) claimCallback(claim *callbackClaim) {
+	c := claim.client
+	if _, ok := h.helpers[c]; !ok {
+		h.failCallback(c, "Only the helpers could claim the callbacks")
+		return
+	}
+
+	index := -1
+	for i, callback := range h.callbacks {
+		if claim.callbackID == uuid.Nil || callback.ID == claim.callbackID {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		h.failCallback(c, "There is no callback to claim")
+		return
+	}
+
+	callback := h.callbacks[index]
+	h.callbacks = append(h.callbacks[:index], h.callbacks[index+1:]...)
+	metrics.PendingCallbacks.Set(float64(len(h.callbacks)))
+
+	c.logger().WithFields(log.Fields{
+		"callback_id": callback.ID,
+		"callback_to": callback.UserID,
+	}).Info("the helper has claimed a callback")
+
+	content, err := json.Marshal(callback)
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the callback claimed payload")
+		return
+	}
+	c.post(connectionMessage{Typ: outgoingMessageCallbackClaimed, Content: content})
+}
+
+// failCallback reports the rejected callback request or claim to the client without waiting for it
+func (h *hub) failCallback(c *client, desc string) {
+	go c.reportError(messageHandleError{Code: errorCodeCallback, Desc: desc})
+}
+
+// recordAbandoned records the call its caller has hung up while it was waiting for a helper
+func (h *hub) recordAbandoned(p *pair) {
+	if p.isAnswered() {
+		return
+	}
+
+	call := h.findQueuedCall(p)
+	if call == nil {
+		return
+	}
+
+	wait := time.Since(call.enqueuedAt)
+	metrics.AbandonedWaitDuration.Observe(wait.Seconds())
+	call.caller.logger().WithFields(log.Fields{
+		"pair_id":      p.id,
+		"queue_wait":   wait.String(),
+		"priority":     p.call.Priority,
+		"requirements": call.requirements,
+		"offers":       call.offers,
+	}).Info("the call has been abandoned")
+
+	h.abandoned = append(h.abandoned, &AbandonedCall{
+		PairID:       p.id,
+		AbandonedAt:  time.Now(),
+		Waited:       wait.Seconds(),
+		Requirements: call.requirements,
+		Offers:       call.offers,
+		Call:         &p.call,
+	})
+	if len(h.abandoned) > maxAbandoned {
+		h.abandoned = h.abandoned[len(h.abandoned)-maxAbandoned:]
+	}
+}
+
+// findQueuedCall returns the call of the pair either waiting in the queue or offered to a helper
+func (h *hub) findQueuedCall(p *pair) *queuedCall {
+	for _, call := range h.queue {
+		if call.pair == p {
+			return call
+		}
+	}
+
+	for _, state := range h.helpers {
+		if state.assigned != nil && state.assigned.pair == p {
+			return state.assigned
+		}
+	}
+
+	return nil
+}
+
+// Callbacks returns the callback requests no helper has claimed yet
+func (s *Server) Callbacks(ctx context.Context) ([]*Callback, error) {
+	result := make(chan []*Callback, 1)
+
+	select {
+	case s.hub.listCallbacks <- result:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "the hub didn't respond")
+	}
+
+	return <-result, nil
+}
+
+// AbandonedCalls returns the latest calls the callers have hung up while waiting for a helper, the oldest one goes first
+func (s *Server) AbandonedCalls(ctx context.Context) ([]*AbandonedCall, error) {
+	result := make(chan []*AbandonedCall, 1)
+
+	select {
+	case s.hub.listAbandoned <- result:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "the hub didn't respond")
+	}
+
+	return <-result, nil
+}
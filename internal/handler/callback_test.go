@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+func TestHub_callback(t *testing.T) {
+	h := newTestHub(t, nil, Options{})
+	callerConn := h.connect("alice")
+	helperConn := h.connect("helper")
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available","skills":["en"]}`)})
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessagePresence, Content: []byte("alice")})
+	if _, err := testExpectMessage(callerConn, outgoingMessagePresenceRegistered); err != nil {
+		t.Fatal(err)
+	}
+
+	// no helper has the required skill so the call stays in the queue
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"helper":true,"requirements":["de"]}`)})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCallbackRequest})
+	msg, err := testExpectMessage(callerConn, outgoingMessageCallbackRegistered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var registered callbackRegisteredPayload
+	if err := json.Unmarshal(msg.Content, &registered); err != nil {
+		t.Fatalf("couldn't decode the callback registration: %s", err)
+	}
+
+	// the pair of the call is removed while the caller stays connected to be called back
+	server := &Server{hub: h.hub}
+	if _, err := server.Pair(context.Background(), registered.PairID); !errors.Is(err, ErrPairNotFound) {
+		t.Errorf("the pair of the callback expected to be removed, got %v", err)
+	}
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageSignaling, Content: []byte(`{"type":"offer","sdp":"v=0"}`)})
+	msg, err = testExpectMessage(callerConn, outgoingMessageError)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handleErr messageHandleError
+	if err := json.Unmarshal(msg.Content, &handleErr); err != nil {
+		t.Fatalf("couldn't decode the error: %s", err)
+	}
+	if handleErr.Code != errorCodeSignaling {
+		t.Errorf("the signaling of the caller waiting for the callback expected to be rejected, got %+v", handleErr)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageCallbackClaim})
+	msg, err = testExpectMessage(helperConn, outgoingMessageCallbackClaimed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var callback Callback
+	if err := json.Unmarshal(msg.Content, &callback); err != nil {
+		t.Fatalf("couldn't decode the claimed callback: %s", err)
+	}
+
+	if callback.ID != registered.CallbackID {
+		t.Errorf("the callback %s expected, got %s", registered.CallbackID, callback.ID)
+	}
+	if callback.UserID != "alice" {
+		t.Errorf("the callback to 'alice' expected, got '%s'", callback.UserID)
+	}
+}
+
+func TestHub_record_abandoned(t *testing.T) {
+	h := newHub(nil, nil, Options{})
+	caller := newClient(newWebSocketConnStub(), h, nil, nil, nil, connectionInfo{})
+	p := &pair{id: uuid.New()}
+	h.queue = []*queuedCall{{pair: p, caller: caller, enqueuedAt: time.Now(), requirements: []string{"de"}, offers: 2}}
+
+	// the oldest abandoned call is dropped once the maximum is reached
+	h.abandoned = make([]*AbandonedCall, maxAbandoned)
+	h.recordAbandoned(p)
+
+	if len(h.abandoned) != maxAbandoned {
+		t.Fatalf("%d abandoned calls expected, got %d", maxAbandoned, len(h.abandoned))
+	}
+
+	recorded := h.abandoned[maxAbandoned-1]
+	if recorded.PairID != p.id || recorded.Offers != 2 || len(recorded.Requirements) != 1 {
+		t.Errorf("the abandoned call of the pair %s expected, got %+v", p.id, recorded)
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	resourceConnections = "connections"
+	resourcePairs       = "pairs"
+
+	defaultRetryAfter = 10 * time.Second
+)
+
+// CapacityLimits configures the maximum load of the server, a zero limit is disabled
+type CapacityLimits struct {
+	// MaxConnections is checked on the upgrade, the rejected clients get 503 with the Retry-After header
+	MaxConnections int
+	// MaxPairs is checked on the call registration, the rejected callers get the capacity error over their connection
+	MaxPairs int
+	// HighWatermark is the fraction of a limit which is logged and counted when it's reached
+	HighWatermark float64
+	// RetryAfter is suggested to the clients rejected because of the capacity
+	RetryAfter time.Duration
+}
+
+// capacity counts the usage of a limited resource, a nil capacity is unlimited
+type capacity struct {
+	resource  string
+	max       int64
+	watermark int64
+	current   atomic.Int64
+	high      atomic.Bool
+}
+
+func newCapacity(resource string, max int, highWatermark float64) *capacity {
+	if max <= 0 {
+		return nil
+	}
+
+	watermark := int64(float64(max) * highWatermark)
+	if watermark <= 0 || watermark > int64(max) {
+		watermark = int64(max)
+	}
+
+	return &capacity{
+		resource:  resource,
+		max:       int64(max),
+		watermark: watermark,
+	}
+}
+
+// tryAcquire takes a unit of the resource if the limit isn't reached yet
+func (c *capacity) tryAcquire() bool {
+	if c == nil {
+		return true
+	}
+
+	for {
+		current := c.current.Load()
+		if current >= c.max {
+			metrics.CapacityRejections.WithLabelValues(c.resource).Inc()
+			return false
+		}
+
+		if c.current.CompareAndSwap(current, current+1) {
+			c.checkWatermark(current + 1)
+			return true
+		}
+	}
+}
+
+func (c *capacity) release() {
+	if c == nil {
+		return
+	}
+
+	c.checkWatermark(c.current.Add(-1))
+}
+
+// set updates the usage of a resource counted elsewhere
+func (c *capacity) set(current int) {
+	if c == nil {
+		return
+	}
+
+	c.current.Store(int64(current))
+	c.checkWatermark(int64(current))
+}
+
+// isFull reports whether the limit is reached, the rejection is counted if so
+func (c *capacity) isFull() bool {
+	if c == nil || c.current.Load() < c.max {
+		return false
+	}
+
+	metrics.CapacityRejections.WithLabelValues(c.resource).Inc()
+	return true
+}
+
+// checkWatermark logs when the usage rises above the high watermark, it's logged once until the usage falls back
+func (c *capacity) checkWatermark(current int64) {
+	if current < c.watermark {
+		c.high.Store(false)
+		return
+	}
+
+	if c.high.CompareAndSwap(false, true) {
+		metrics.CapacityHighWatermark.WithLabelValues(c.resource).Inc()
+		log.WithFields(log.Fields{
+			"resource": c.resource,
+			"current":  current,
+			"max":      c.max,
+		}).Warn("the capacity high watermark is reached")
+	}
+}
+
+func retryAfterSeconds(retryAfter time.Duration) string {
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+
+	return strconv.Itoa(int(retryAfter.Round(time.Second).Seconds()))
+}
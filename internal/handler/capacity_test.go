@@ -0,0 +1,34 @@
+package handler
+
+import "testing"
+
+func TestCapacity_tryAcquire(t *testing.T) {
+	c := newCapacity(resourceConnections, 2, 0.5)
+
+	if !c.tryAcquire() || !c.tryAcquire() {
+		t.Fatal("the resource expected to be acquired within the limit")
+	}
+
+	if c.tryAcquire() {
+		t.Error("the resource expected not to be acquired over the limit")
+	}
+
+	if !c.isFull() {
+		t.Error("the capacity expected to be full")
+	}
+
+	c.release()
+
+	if c.isFull() || !c.tryAcquire() {
+		t.Error("the released resource expected to be acquired again")
+	}
+}
+
+func TestCapacity_unlimited(t *testing.T) {
+	c := newCapacity(resourcePairs, 0, 0.8)
+
+	c.set(1000)
+	if c.isFull() || !c.tryAcquire() {
+		t.Error("a disabled capacity expected to be unlimited")
+	}
+}
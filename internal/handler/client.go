@@ -1,14 +1,21 @@
 package handler
 
 import (
+	"context"
 	"io"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -24,51 +31,144 @@ type webSocketConnection interface {
 	ReadMessage() (messageType int, p []byte, err error)
 	WriteMessage(messageType int, data []byte) error
 	SetWriteDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+}
+
+// connectionInfo describes the HTTP request a client has been upgraded from
+type connectionInfo struct {
+	requestID string
+	subject   string
+	// spanContext is the context of the upgrade span, the call setup spans are its children
+	spanContext trace.SpanContext
 }
 
 type client struct {
 	sync.WaitGroup
+	writeMu             sync.Mutex
+	id                  uuid.UUID
+	entry               atomic.Pointer[log.Entry]
 	conn                webSocketConnection
+	subject             string
+	userID              string // the ID of the user registered in the presence registry, it's owned by the reader
+	isHelper            bool   // whether the client has registered as a helper, it's owned by the reader
+	spanContext         trace.SpanContext
+	connectedAt         time.Time
 	api                 ApiClient
 	hub                 *hub
+	limiter             *limiter
+	iceServers          *IceServers
+	signalingLimiter    *rate.Limiter
+	violations          int // the number of consecutive messages rejected by the rate limits
 	pair                *pair
 	setPair             chan *pair
 	setPairSuccess      chan struct{}
+	pairingFailed       chan struct{}
+	notifications       chan connectionMessage
 	incoming            chan []byte
 	messageHandleErrors chan messageHandleError
-	disconnect          chan struct{}
+	disconnect          chan *messageHandleError
 	terminate           chan struct{}
+	// posted are the notifications of the hub waiting for the writer in the order they've been posted,
+	// they're guarded by postedMu and the sender is woken up through postWakeUp
+	postedMu   sync.Mutex
+	posted     []connectionMessage
+	postWakeUp chan struct{}
 }
 
-func newClient(conn webSocketConnection, hub *hub, apiClient ApiClient) *client {
-	return &client{
+func newClient(
+	conn webSocketConnection,
+	hub *hub,
+	apiClient ApiClient,
+	limiter *limiter,
+	iceServers *IceServers,
+	info connectionInfo,
+) *client {
+	c := &client{
 		WaitGroup:           sync.WaitGroup{},
+		id:                  uuid.New(),
 		conn:                conn,
+		subject:             info.subject,
+		spanContext:         info.spanContext,
+		connectedAt:         time.Now(),
 		api:                 apiClient,
 		hub:                 hub,
+		limiter:             limiter,
+		iceServers:          iceServers,
+		signalingLimiter:    limiter.newSignalingLimiter(),
 		setPair:             make(chan *pair),
 		setPairSuccess:      make(chan struct{}),
+		pairingFailed:       make(chan struct{}),
+		notifications:       make(chan connectionMessage),
 		incoming:            make(chan []byte),
 		messageHandleErrors: make(chan messageHandleError),
-		disconnect:          make(chan struct{}),
+		disconnect:          make(chan *messageHandleError),
 		terminate:           make(chan struct{}),
+		postWakeUp:          make(chan struct{}, 1),
 	}
+
+	c.entry.Store(log.WithFields(log.Fields{
+		"conn_id":     c.id,
+		"request_id":  info.requestID,
+		"remote_addr": conn.RemoteAddr().String(),
+		"subject":     info.subject,
+	}))
+
+	return c
+}
+
+// traceContext returns a context carrying the span of the client's upgrade request
+func (c *client) traceContext() context.Context {
+	return trace.ContextWithRemoteSpanContext(context.Background(), c.spanContext)
+}
+
+// logger returns the log entry with the fields identifying the client
+func (c *client) logger() *log.Entry {
+	return c.entry.Load()
 }
 
 func (c *client) run() {
-	c.Add(3)
+	metrics.ConnectedClients.Inc()
+	defer metrics.ConnectedClients.Dec()
+
+	c.Add(4)
 	go c.handleWebSocketMessage()
 	go c.handleIncomingMessage()
 	go c.handleError()
+	go c.sendPosted()
 	c.Wait()
 
-	c.hub.unregister <- c.pair
+	if c.pair != nil {
+		c.leavePair()
+	}
+
+	if c.userID != "" {
+		c.hub.offline <- &presence{client: c, userID: c.userID}
+	}
+
+	if c.isHelper {
+		c.hub.helperStatus <- &helperStatus{client: c, left: true}
+	}
+}
+
+// write writes raw data to the connection, gorilla's connection supports only one concurrent writer
+func (c *client) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// writeMessage encodes and writes a connection message
+func (c *client) writeMessage(messageType int, msg connectionMessage) error {
+	metrics.OutgoingMessages.WithLabelValues(msg.Typ.String()).Inc()
+
+	return c.write(messageType, msg.Encode())
 }
 
 func (c *client) handleWebSocketMessage() {
 	defer func() {
-		if err := c.conn.WriteMessage(websocket.CloseMessage, []byte{}); err != nil {
-			log.WithError(err).Error("error while writing closing message")
+		if err := c.write(websocket.CloseMessage, []byte{}); err != nil {
+			c.logger().WithError(err).Error("error while writing closing message")
 		}
 		close(c.terminate)
 		c.Done()
@@ -77,13 +177,13 @@ func (c *client) handleWebSocketMessage() {
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			log.WithError(err).Error("error while trying to read a message from the socket")
-
 			var closeError *websocket.CloseError
-			if errors.As(err, closeError) {
-				return
+			if !errors.As(err, &closeError) {
+				c.logger().WithError(err).Error("error while trying to read a message from the socket")
 			}
-			continue
+
+			// all subsequent reads return the same error after a failure, so the connection is done
+			return
 		}
 
 		if message == nil {
@@ -91,7 +191,7 @@ func (c *client) handleWebSocketMessage() {
 		}
 
 		if err := handleWebSocketRawMessage(c, message); err != nil {
-			log.WithError(err).Error("error while handling incoming message")
+			c.logger().WithError(err).Error("error while handling incoming message")
 		}
 	}
 }
@@ -112,22 +212,33 @@ func (c *client) handleIncomingMessage() {
 				Content: data,
 			}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg.Encode()); err != nil {
-				log.WithError(err).Error("couldn't write message to the connection")
+			if err := c.writeMessage(websocket.TextMessage, msg); err != nil {
+				c.logger().WithError(err).WithField("message_type", msg.Typ.String()).
+					Error("couldn't write message to the connection")
+			}
+		case msg := <-c.notifications:
+			if err := c.writeMessage(websocket.BinaryMessage, msg); err != nil {
+				c.logger().WithError(err).WithField("message_type", msg.Typ.String()).
+					Error("couldn't write message to the connection")
 			}
 		case p := <-c.setPair:
 			c.pair = p
+			c.entry.Store(c.logger().WithField("pair_id", p.id))
 			c.setPairSuccess <- struct{}{}
 		case <-ticker.C:
 			if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
-				log.WithError(err).Error("error while setting write deadline")
+				c.logger().WithError(err).Error("error while setting write deadline")
+			}
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.logger().WithError(err).Error("error on trying to ping")
 			}
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.WithError(err).Error("error on trying to ping")
+		case reason := <-c.disconnect:
+			if reason != nil {
+				c.writeError(*reason)
 			}
-		case <-c.disconnect:
+
 			if err := c.conn.Close(); err != nil {
-				log.WithError(err).Error("error while trying to close a client's connection")
+				c.logger().WithError(err).Error("error while trying to close a client's connection")
 			}
 		case <-c.terminate:
 			return
@@ -141,52 +252,173 @@ func (c *client) handleError() {
 	for {
 		select {
 		case msgHandleError := <-c.messageHandleErrors:
-			content, err := msgHandleError.Encode()
-			if err != nil {
-				log.WithError(err).Error("error while trying to encode handle error message")
-			}
+			c.writeError(msgHandleError)
+		case <-c.terminate:
+			return
+		}
+	}
+}
 
-			msg := connectionMessage{
-				Typ:     outgoingMessageError,
-				Content: content,
-			}
+// notify sends a message to the client unless it has already left, it reports whether the message has been sent
+func (c *client) notify(msg connectionMessage) bool {
+	select {
+	case c.notifications <- msg:
+		return true
+	case <-c.terminate:
+		return false
+	}
+}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg.Encode()); err != nil {
-				log.WithError(err).Error("couldn't write message to the connection")
-			}
+// post queues the notification of the hub without waiting for the client's writer,
+// the posted notifications are written in the order they've been posted
+func (c *client) post(msg connectionMessage) {
+	c.postedMu.Lock()
+	c.posted = append(c.posted, msg)
+	c.postedMu.Unlock()
+
+	select {
+	case c.postWakeUp <- struct{}{}:
+	default:
+		// the sender is already woken up
+	}
+}
+
+// sendPosted sends the posted notifications to the writer one after another until the client leaves
+func (c *client) sendPosted() {
+	defer c.Done()
+
+	for {
+		select {
+		case <-c.postWakeUp:
 		case <-c.terminate:
 			return
 		}
+
+		c.postedMu.Lock()
+		posted := c.posted
+		c.posted = nil
+		c.postedMu.Unlock()
+
+		for _, msg := range posted {
+			if !c.notify(msg) {
+				return
+			}
+		}
+	}
+}
+
+// failPairing reports the reason the client couldn't be paired and releases the client waiting for a pair
+func (c *client) failPairing(reason messageHandleError) {
+	c.messageHandleErrors <- reason
+	c.pairingFailed <- struct{}{}
+}
+
+// reportError sends the error to the client unless it has already left
+func (c *client) reportError(reason messageHandleError) {
+	select {
+	case c.messageHandleErrors <- reason:
+	case <-c.terminate:
+	}
+}
+
+func (c *client) writeError(msgHandleError messageHandleError) {
+	metrics.MessageHandleErrors.WithLabelValues(strconv.Itoa(msgHandleError.Code)).Inc()
+
+	content, err := msgHandleError.Encode()
+	if err != nil {
+		c.logger().WithError(err).Error("error while trying to encode handle error message")
+	}
+
+	msg := connectionMessage{
+		Typ:     outgoingMessageError,
+		Content: content,
+	}
+
+	if err := c.writeMessage(websocket.TextMessage, msg); err != nil {
+		c.logger().WithError(err).WithField("message_type", msg.Typ.String()).
+			Error("couldn't write message to the connection")
 	}
 }
 
 func handleWebSocketRawMessage(c *client, data []byte) error {
-	incomingConnectionMessage, err := newConnectionMessageFromBytes(data)
+	incomingConnectionMessage, err := newConnectionMessageFromBytes(data, c.limiter.messageSizes())
 	if err != nil {
+		var tooLarge *messageTooLargeError
+		if errors.As(err, &tooLarge) {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeMessageTooLarge,
+				Desc: "Message too large",
+			}
+		}
 		return errors.Wrap(err, "couldn't create message from raw data")
 	}
 
+	metrics.IncomingMessages.WithLabelValues(incomingConnectionMessage.Typ.String()).Inc()
+	logger := c.logger().WithField("message_type", incomingConnectionMessage.Typ.String())
+
+	// the previous callee of a transferred pair could take another call
+	if c.pair != nil && c.pair.hasReleased(c) {
+		logger.WithField("pair_id", c.pair.id).Debug("the client has been released from the pair")
+		c.pair = nil
+	}
+
 	switch incomingConnectionMessage.Typ {
 	case incomingMessageCall:
-		c.hub.register <- c
-		log.Debug("client sent to the hub")
+		if !c.limiter.allowCall(c) {
+			return c.rejectRateLimited()
+		}
+		c.violations = 0
+
+		call, err := parseCallRequest(incomingConnectionMessage.Content)
+		if err != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeCall,
+				Desc: "Invalid call request",
+			}
+			return err
+		}
+
+		ctx, span := tracer.Start(c.traceContext(), "incomingMessageCall")
+		defer span.End()
+
+		c.hub.register <- &registration{ctx: ctx, client: c, call: call}
+		logger.Debug("client sent to the hub")
 
 		select {
+		case <-c.pairingFailed:
+			return errors.New("the call couldn't be registered in the hub")
 		case <-c.setPairSuccess:
-			if err := c.api.Call(c.pair.id); err != nil {
-				c.messageHandleErrors <- messageHandleError{
-					Code: errorCodeCall,
-					Desc: "Couldn't initialized a call",
+			if !call.Helper {
+				if err := c.notifyCallee(ctx, c.pair); err != nil {
+					c.messageHandleErrors <- messageHandleError{
+						Code: errorCodeCall,
+						Desc: "Couldn't initialized a call",
+					}
+					return errors.Wrap(err, "error response received from the API")
 				}
-				return errors.Wrap(err, "error response received from the API")
+			}
+
+			content, err := encodeCallPayload(c, nil)
+			if err != nil {
+				return errors.Wrap(err, "couldn't encode the call initialized payload")
 			}
 
 			msg := connectionMessage{
 				Typ:     outgoingMessageCallInitialized,
-				Content: nil,
+				Content: content,
+			}
+			if err := c.writeMessage(websocket.BinaryMessage, msg); err != nil {
+				logger.WithError(err).Error("couldn't write message to the connection")
 			}
-			if err := c.conn.WriteMessage(websocket.BinaryMessage, msg.Encode()); err != nil {
-				log.WithError(err).Error("couldn't write message to the connection")
+
+			// the call is queued after the call initialized message, so the caller gets the assignment after it
+			if call.Helper {
+				c.hub.enqueue <- &queuedCall{
+					pair:         c.pair,
+					caller:       c,
+					enqueuedAt:   time.Now(),
+					requirements: call.Requirements,
+				}
 			}
 		}
 	case incomingMessageAnswer:
@@ -199,22 +431,217 @@ func handleWebSocketRawMessage(c *client, data []byte) error {
 			return errors.Wrap(err, "invalid pairID format")
 		}
 
-		c.hub.pair <- &pairInfo{client: c, pairID: pairID}
+		ctx, span := tracer.Start(c.traceContext(), "incomingMessageAnswer")
+		defer span.End()
+
+		c.hub.pair <- &pairInfo{ctx: ctx, client: c, pairID: pairID}
 
 		select {
+		case <-c.pairingFailed:
+			return errors.Errorf("the pair %s couldn't be answered", pairID)
 		case <-c.setPairSuccess:
+			content, err := encodeCallPayload(c, &c.pair.call)
+			if err != nil {
+				return errors.Wrap(err, "couldn't encode the answer accepted payload")
+			}
+
 			msg := connectionMessage{
 				Typ:     outgoingMessageAnswerAccepted,
-				Content: nil,
+				Content: content,
+			}
+			if err := c.writeMessage(websocket.BinaryMessage, msg); err != nil {
+				logger.WithError(err).Error("couldn't write message to the connection")
+			}
+
+			if c.isHelper {
+				c.hub.answered <- c
+			}
+		}
+	case incomingMessagePresence:
+		userID, err := parseUserID(incomingConnectionMessage.Content, c.subject)
+		if err != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodePresence,
+				Desc: "Invalid user ID",
+			}
+			return err
+		}
+
+		c.registerPresence(userID)
+
+		msg := connectionMessage{Typ: outgoingMessagePresenceRegistered}
+		if err := c.writeMessage(websocket.BinaryMessage, msg); err != nil {
+			logger.WithError(err).Error("couldn't write message to the connection")
+		}
+	case incomingMessageHelperStatus:
+		// the helpers get the metadata of the distress calls, so they must be authenticated by the proxy
+		if c.subject == "" {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeHelper,
+				Desc: "Only the authenticated clients could register as helpers",
+			}
+			return errors.New("helper status sent by an anonymous client")
+		}
+
+		status, err := parseHelperStatus(incomingConnectionMessage.Content)
+		if err != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeHelper,
+				Desc: "Invalid helper status",
+			}
+			return err
+		}
+
+		c.isHelper = true
+		status.client = c
+		c.hub.helperStatus <- status
+	case incomingMessageCallDeclined:
+		pairID, err := uuid.FromBytes(incomingConnectionMessage.Content)
+		if err != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodePairID,
+				Desc: "Invalid pairID format",
+			}
+			return errors.Wrap(err, "invalid pairID format")
+		}
+
+		c.hub.decline <- &offerDecline{client: c, pairID: pairID}
+	case incomingMessageTransfer:
+		request, err := parseTransferRequest(incomingConnectionMessage.Content)
+		if err != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeTransfer,
+				Desc: "Invalid transfer request",
+			}
+			return err
+		}
+
+		if c.pair == nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeTransfer,
+				Desc: "The client isn't paired",
+			}
+			return errors.New("transfer requested by a client without a pair")
+		}
+
+		request.client = c
+		request.pairID = c.pair.id
+		c.hub.transfer <- request
+	case incomingMessageHold, incomingMessageResume:
+		if c.pair == nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeHold,
+				Desc: "The client isn't paired",
+			}
+			return errors.New("hold requested by a client without a pair")
+		}
+
+		select {
+		case c.pair.hold <- &holdRequest{client: c, resume: incomingConnectionMessage.Typ == incomingMessageResume}:
+		case <-c.pair.terminate:
+		}
+	case incomingMessageCallbackRequest:
+		userID, err := parseCallbackUserID(incomingConnectionMessage.Content, c.subject)
+		if err != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeCallback,
+				Desc: "Invalid callback request",
+			}
+			return err
+		}
+
+		if c.pair == nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeCallback,
+				Desc: "The client isn't waiting for a helper",
 			}
-			if err := c.conn.WriteMessage(websocket.BinaryMessage, msg.Encode()); err != nil {
-				log.WithError(err).Error("couldn't write message to the connection")
+			return errors.New("callback requested by a client without a pair")
+		}
+
+		c.hub.callbackRequests <- &callbackRequest{client: c, pairID: c.pair.id, userID: userID}
+	case incomingMessageCallbackClaim:
+		// the first callback in the order of the priorities is claimed if no ID is given
+		callbackID := uuid.Nil
+		if len(incomingConnectionMessage.Content) > 0 {
+			id, err := uuid.FromBytes(incomingConnectionMessage.Content)
+			if err != nil {
+				c.messageHandleErrors <- messageHandleError{
+					Code: errorCodeCallback,
+					Desc: "Invalid callback ID format",
+				}
+				return errors.Wrap(err, "invalid callback ID format")
 			}
+			callbackID = id
+		}
+
+		c.hub.callbackClaims <- &callbackClaim{client: c, callbackID: callbackID}
+	case incomingMessageMonitor:
+		pairID, err := uuid.FromBytes(incomingConnectionMessage.Content)
+		if err != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodePairID,
+				Desc: "Invalid pairID format",
+			}
+			return errors.Wrap(err, "invalid pairID format")
+		}
+
+		if c.pair != nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeMonitoring,
+				Desc: "The client is already paired",
+			}
+			return errors.New("a paired client has requested monitoring")
+		}
+
+		c.hub.monitor <- &monitorRequest{client: c, pairID: pairID}
+
+		select {
+		case <-c.pairingFailed:
+			return errors.Errorf("the pair %s couldn't be monitored", pairID)
+		case <-c.setPairSuccess:
+			content, err := encodeCallPayload(c, &c.pair.call)
+			if err != nil {
+				return errors.Wrap(err, "couldn't encode the monitor accepted payload")
+			}
+
+			msg := connectionMessage{
+				Typ:     outgoingMessageMonitorAccepted,
+				Content: content,
+			}
+			if err := c.writeMessage(websocket.BinaryMessage, msg); err != nil {
+				logger.WithError(err).Error("couldn't write message to the connection")
+			}
+		}
+	case incomingMessageBargeIn:
+		if c.pair == nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeMonitoring,
+				Desc: "The client isn't monitoring a call",
+			}
+			return errors.New("barge in requested by a client without a pair")
+		}
+
+		select {
+		case c.pair.barge <- c:
+		case <-c.pair.terminate:
 		}
 	case incomingMessageSignaling:
-		c.pair.broadcast <- &broadcast{
-			client: c,
-			data:   incomingConnectionMessage.Content,
+		if c.signalingLimiter != nil && !countRejection(limitSignaling, c.signalingLimiter.Allow()) {
+			return c.rejectRateLimited()
+		}
+		c.violations = 0
+
+		if c.pair == nil {
+			c.messageHandleErrors <- messageHandleError{
+				Code: errorCodeSignaling,
+				Desc: "The client isn't paired",
+			}
+			return errors.New("signaling received from a client without a pair")
+		}
+
+		select {
+		case c.pair.broadcast <- &broadcast{client: c, data: incomingConnectionMessage.Content}:
+		case <-c.pair.terminate:
 		}
 	default:
 		return errors.Errorf(
@@ -225,3 +652,21 @@ func handleWebSocketRawMessage(c *client, data []byte) error {
 
 	return nil
 }
+
+// rejectRateLimited reports a message rejected by a rate limit to the client
+// and closes the connection if the limits are violated persistently
+func (c *client) rejectRateLimited() error {
+	c.violations++
+	reason := messageHandleError{
+		Code: errorCodeRateLimited,
+		Desc: "Too many requests",
+	}
+
+	if c.limiter.isAbuse(c.violations) {
+		c.disconnect <- &reason
+		return errors.Errorf("the connection is closed after %d rate limit violations", c.violations)
+	}
+
+	c.messageHandleErrors <- reason
+	return errors.New("the message is rejected by the rate limit")
+}
@@ -1,9 +1,7 @@
 package handler
 
 import (
-	"bytes"
-	"encoding/binary"
-	"io"
+	"fmt"
 
 	"github.com/pkg/errors"
 )
@@ -21,18 +19,150 @@ const (
 	outgoingMessageCallInitialized
 	outgoingMessageAnswerAccepted
 	outgoingMessageError
+
+	// the types added later are appended to keep the values of the existing ones
+	incomingMessagePresence
+	outgoingMessagePresenceRegistered
+	outgoingMessageIncomingCall
+	outgoingMessageAnsweredElsewhere
+	incomingMessageHelperStatus
+	outgoingMessageCallAssigned
+	outgoingMessageHelperAssigned
+	outgoingMessageQueueStatus
+	outgoingMessageQueueFallback
+	incomingMessageCallDeclined
+	outgoingMessageOfferWithdrawn
+	outgoingMessageHelperState
+	incomingMessageMonitor
+	outgoingMessageMonitorAccepted
+	incomingMessageBargeIn
+	outgoingMessageParticipantJoined
+	outgoingMessageParticipantLeft
+	outgoingMessageParticipantSignaling
+	incomingMessageTransfer
+	outgoingMessageTransferOffered
+	outgoingMessageTransferred
+	outgoingMessageTransferCompleted
+	incomingMessageHold
+	incomingMessageResume
+	outgoingMessageHeld
+	outgoingMessageResumed
+	incomingMessageCallbackRequest
+	outgoingMessageCallbackRegistered
+	incomingMessageCallbackClaim
+	outgoingMessageCallbackClaimed
+	outgoingMessageCallEnded
 )
 
+func (t MessageType) String() string {
+	switch t {
+	case incomingMessageSignaling:
+		return "incoming_signaling"
+	case incomingMessageCall:
+		return "incoming_call"
+	case incomingMessageAnswer:
+		return "incoming_answer"
+	case outgoingMessageSignaling:
+		return "outgoing_signaling"
+	case outgoingMessageCallInitialized:
+		return "outgoing_call_initialized"
+	case outgoingMessageAnswerAccepted:
+		return "outgoing_answer_accepted"
+	case outgoingMessageError:
+		return "outgoing_error"
+	case incomingMessagePresence:
+		return "incoming_presence"
+	case outgoingMessagePresenceRegistered:
+		return "outgoing_presence_registered"
+	case outgoingMessageIncomingCall:
+		return "outgoing_incoming_call"
+	case outgoingMessageAnsweredElsewhere:
+		return "outgoing_answered_elsewhere"
+	case incomingMessageHelperStatus:
+		return "incoming_helper_status"
+	case outgoingMessageCallAssigned:
+		return "outgoing_call_assigned"
+	case outgoingMessageHelperAssigned:
+		return "outgoing_helper_assigned"
+	case outgoingMessageQueueStatus:
+		return "outgoing_queue_status"
+	case outgoingMessageQueueFallback:
+		return "outgoing_queue_fallback"
+	case incomingMessageCallDeclined:
+		return "incoming_call_declined"
+	case outgoingMessageOfferWithdrawn:
+		return "outgoing_offer_withdrawn"
+	case outgoingMessageHelperState:
+		return "outgoing_helper_state"
+	case incomingMessageMonitor:
+		return "incoming_monitor"
+	case outgoingMessageMonitorAccepted:
+		return "outgoing_monitor_accepted"
+	case incomingMessageBargeIn:
+		return "incoming_barge_in"
+	case outgoingMessageParticipantJoined:
+		return "outgoing_participant_joined"
+	case outgoingMessageParticipantLeft:
+		return "outgoing_participant_left"
+	case outgoingMessageParticipantSignaling:
+		return "outgoing_participant_signaling"
+	case incomingMessageTransfer:
+		return "incoming_transfer"
+	case outgoingMessageTransferOffered:
+		return "outgoing_transfer_offered"
+	case outgoingMessageTransferred:
+		return "outgoing_transferred"
+	case outgoingMessageTransferCompleted:
+		return "outgoing_transfer_completed"
+	case incomingMessageHold:
+		return "incoming_hold"
+	case incomingMessageResume:
+		return "incoming_resume"
+	case outgoingMessageHeld:
+		return "outgoing_held"
+	case outgoingMessageResumed:
+		return "outgoing_resumed"
+	case incomingMessageCallbackRequest:
+		return "incoming_callback_request"
+	case outgoingMessageCallbackRegistered:
+		return "outgoing_callback_registered"
+	case incomingMessageCallbackClaim:
+		return "incoming_callback_claim"
+	case outgoingMessageCallbackClaimed:
+		return "outgoing_callback_claimed"
+	case outgoingMessageCallEnded:
+		return "outgoing_call_ended"
+	default:
+		return "unknown"
+	}
+}
+
 type connectionMessage struct {
 	Typ     MessageType
 	Content []byte
 }
 
-func newConnectionMessageFromBytes(data []byte) (*connectionMessage, error) {
-	r := bytes.NewReader(data)
-	var typ MessageType
-	if err := binary.Read(r, binary.LittleEndian, &typ); err != nil && err != io.EOF {
-		return nil, errors.Wrap(err, "could not parse binary message")
+// messageSizeLimits maps a message type to the maximum size of its content, the unlisted types aren't limited
+type messageSizeLimits map[MessageType]int
+
+type messageTooLargeError struct {
+	typ     MessageType
+	size    int
+	maxSize int
+}
+
+func (e *messageTooLargeError) Error() string {
+	return fmt.Sprintf("the %s message content of %d bytes exceeds the limit of %d bytes", e.typ, e.size, e.maxSize)
+}
+
+func newConnectionMessageFromBytes(data []byte, limits messageSizeLimits) (*connectionMessage, error) {
+	if len(data) == 0 {
+		return nil, errors.New("could not parse an empty binary message")
+	}
+
+	typ := MessageType(data[0])
+	if maxSize, ok := limits[typ]; ok && len(data)-1 > maxSize {
+		return nil, &messageTooLargeError{typ: typ, size: len(data) - 1, maxSize: maxSize}
 	}
 
 	return &connectionMessage{
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestNewConnectionMessageFromBytes(t *testing.T) {
+	msg, err := newConnectionMessageFromBytes([]byte{byte(incomingMessageSignaling), 'o', 'k'}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if msg.Typ != incomingMessageSignaling || !bytes.Equal(msg.Content, []byte("ok")) {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+
+	if _, err := newConnectionMessageFromBytes([]byte{}, nil); err == nil {
+		t.Error("an error expected for an empty message")
+	}
+}
+
+func TestNewConnectionMessageFromBytes_size_limit(t *testing.T) {
+	limits := messageSizeLimits{incomingMessageSignaling: 2}
+
+	if _, err := newConnectionMessageFromBytes([]byte{byte(incomingMessageSignaling), 1, 2}, limits); err != nil {
+		t.Errorf("a message within the limit expected to be parsed, got %s", err)
+	}
+
+	_, err := newConnectionMessageFromBytes([]byte{byte(incomingMessageSignaling), 1, 2, 3}, limits)
+	var tooLarge *messageTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Errorf("message too large error expected, got %v", err)
+	}
+
+	if _, err := newConnectionMessageFromBytes([]byte{byte(incomingMessageCall), 1, 2, 3}, limits); err != nil {
+		t.Errorf("a message of an unlimited type expected to be parsed, got %s", err)
+	}
+}
+
+func TestNewLimiter_control_size(t *testing.T) {
+	sizes := newLimiter(RateLimits{}, MessageSizeLimits{Signaling: 8, Control: 4}).messageSizes()
+
+	for _, typ := range []MessageType{incomingMessageHelperStatus, incomingMessageTransfer, incomingMessageCallbackRequest} {
+		if sizes[typ] != 4 {
+			t.Errorf("the control size expected for the %s message, got %d", typ, sizes[typ])
+		}
+	}
+
+	if sizes[incomingMessageSignaling] != 8 {
+		t.Errorf("the signaling size expected for the signaling message, got %d", sizes[incomingMessageSignaling])
+	}
+}
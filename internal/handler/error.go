@@ -6,6 +6,19 @@ const (
 	errorCodePairing = 100 + iota
 	errorCodeCall
 	errorCodePairID
+	errorCodeAdminTerminated
+	errorCodeRateLimited
+	errorCodeMessageTooLarge
+	// errorCodeCapacity (106) is sent to the callers registering a call while the maximum number of pairs is reached,
+	// the connection is kept so the caller could retry the call
+	errorCodeCapacity
+	errorCodeSignaling
+	errorCodePresence
+	errorCodeHelper
+	errorCodeMonitoring
+	errorCodeTransfer
+	errorCodeHold
+	errorCodeCallback
 )
 
 type messageHandleError struct {
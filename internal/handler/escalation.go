@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/api"
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The reasons a call offered to a helper is withdrawn
+const (
+	offerDeclined = "declined"
+	offerTimeout  = "timeout"
+)
+
+// offerDecline is sent to the hub by a helper declining the call offered to them
+type offerDecline struct {
+	client *client
+	pairID uuid.UUID
+}
+
+// offerWithdrawnPayload is the content of the message telling the helper the offered call isn't theirs anymore
+type offerWithdrawnPayload struct {
+	PairID uuid.UUID `json:"pair_id"`
+	Reason string    `json:"reason"`
+}
+
+// declineOffer withdraws the call offered to the helper and offers it to the next one
+func (h *hub) declineOffer(decline *offerDecline) {
+	state, ok := h.helpers[decline.client]
+	if !ok || state.assigned == nil || state.assigned.pair.id != decline.pairID ||
+		!h.isWaitingForHelper(decline.client, state.assigned) {
+		decline.client.logger().WithField("pair_id", decline.pairID).Debug("there is no offered call to decline")
+		return
+	}
+
+	h.withdrawOffer(decline.client, state, offerDeclined)
+	h.setHelperState(decline.client, state, helperStateAvailable)
+
+	h.distribute()
+}
+
+// expireOffers withdraws the calls the helpers haven't answered in time, the helpers are put on break
+func (h *hub) expireOffers() {
+	timeout := h.queueOptions.OfferTimeout
+	if timeout <= 0 {
+		return
+	}
+
+	for c, state := range h.helpers {
+		if state.assigned == nil || time.Since(state.assignedAt) < timeout || !h.isWaitingForHelper(c, state.assigned) {
+			continue
+		}
+
+		h.withdrawOffer(c, state, offerTimeout)
+		h.setHelperState(c, state, helperStateOnBreak)
+	}
+}
+
+// withdrawOffer puts the call offered to the helper back to the head of the queue excluding the helper from its candidates
+// unless it's still offered to the others, the call is escalated once it has been offered to the maximum number of helpers
+func (h *hub) withdrawOffer(helper *client, state *helperState, reason string) {
+	call := state.assigned
+	state.assigned = nil
+
+	call.offers++
+	if call.declinedBy == nil {
+		call.declinedBy = make(map[*client]bool)
+	}
+	call.declinedBy[helper] = true
+	if call.transfer {
+		call.pair.removeTransferTarget(helper)
+	}
+
+	metrics.OffersWithdrawn.WithLabelValues(reason).Inc()
+	helper.logger().WithFields(log.Fields{
+		"pair_id": call.pair.id,
+		"reason":  reason,
+		"offers":  call.offers,
+	}).Info("the call offered to the helper is withdrawn")
+
+	if maxOffers := h.queueOptions.MaxOffers; maxOffers > 0 && call.offers >= maxOffers && !call.escalated {
+		h.escalate(call)
+	}
+
+	h.requeue(call)
+
+	content, err := json.Marshal(&offerWithdrawnPayload{PairID: call.pair.id, Reason: reason})
+	if err != nil {
+		helper.logger().WithError(err).Error("couldn't encode the offer withdrawn payload")
+		return
+	}
+	helper.post(connectionMessage{Typ: outgoingMessageOfferWithdrawn, Content: content})
+}
+
+// escalate routes the call to the supervisors only regardless of their skills and notifies the API about the escalation
+func (h *hub) escalate(call *queuedCall) {
+	escalation := &api.EscalationRequest{
+		PairID:       call.pair.id,
+		Offers:       call.offers,
+		Requirements: call.requirements,
+		Media:        call.pair.call.Media,
+		DisplayName:  call.pair.call.DisplayName,
+		Custom:       call.pair.call.Custom,
+		Priority:     call.pair.call.Priority,
+	}
+
+	call.escalated = true
+
+	metrics.EscalatedCalls.Inc()
+	logger := call.caller.logger().WithFields(log.Fields{
+		"pair_id":      call.pair.id,
+		"offers":       call.offers,
+		"requirements": escalation.Requirements,
+	})
+	logger.Warn("the call is escalated to the supervisors")
+
+	if h.api == nil {
+		return
+	}
+
+	// the hub doesn't wait for the API
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), call.pair.spanContext)
+	go func() {
+		if err := escalateApi(ctx, h.api, escalation); err != nil {
+			logger.WithError(err).Error("couldn't notify the API about the escalation")
+		}
+	}()
+}
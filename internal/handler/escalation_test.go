@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/api"
+	"github.com/google/uuid"
+)
+
+func TestHub_escalate_declined_call(t *testing.T) {
+	apiClient := &escalationApiClientStub{escalations: make(chan *api.EscalationRequest, 1)}
+	h := newTestHub(t, apiClient, Options{Supervisors: []string{"supervisor"}, Queue: QueueOptions{MaxOffers: 1}})
+	callerConn := h.connect("")
+	helperConn := h.connect("helper")
+	supervisorConn := h.connect("supervisor")
+
+	testSendMessage(supervisorConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+	testSendMessage(helperConn, connectionMessage{
+		Typ:     incomingMessageHelperStatus,
+		Content: []byte(`{"status":"available","skills":["de"]}`),
+	})
+
+	testSendMessage(callerConn, connectionMessage{
+		Typ:     incomingMessageCall,
+		Content: []byte(`{"helper":true,"requirements":["de"]}`),
+	})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := testExpectMessage(helperConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageCallDeclined, Content: assigned.PairID[:]})
+	if _, err := testExpectMessage(helperConn, outgoingMessageOfferWithdrawn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(supervisorConn, outgoingMessageCallAssigned); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case escalation := <-apiClient.escalations:
+		if escalation.PairID != assigned.PairID || escalation.Offers != 1 || len(escalation.Requirements) != 1 {
+			t.Errorf("unexpected escalation: %+v", escalation)
+		}
+	case <-time.After(time.Second):
+		t.Error("the API expected to be notified about the escalation")
+	}
+}
+
+type escalationApiClientStub struct {
+	escalations chan *api.EscalationRequest
+}
+
+func (c *escalationApiClientStub) Call(_ context.Context, _ *api.CallRequest) error {
+	return nil
+}
+
+func (c *escalationApiClientStub) Escalate(_ context.Context, escalation *api.EscalationRequest) error {
+	c.escalations <- escalation
+	return nil
+}
+
+func TestHub_expire_offer(t *testing.T) {
+	apiClient := &escalationApiClientStub{escalations: make(chan *api.EscalationRequest, 1)}
+	h := newTestHub(t, apiClient, Options{
+		Supervisors: []string{"supervisor"},
+		Queue:       QueueOptions{OfferTimeout: time.Millisecond, MaxOffers: 1},
+	})
+	callerConn := h.connect("")
+	helperConn := h.connect("helper")
+	supervisorConn := h.connect("supervisor")
+
+	testSendMessage(supervisorConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+	testSendMessage(helperConn, connectionMessage{
+		Typ:     incomingMessageHelperStatus,
+		Content: []byte(`{"status":"available","skills":["de"]}`),
+	})
+
+	testSendMessage(callerConn, connectionMessage{
+		Typ:     incomingMessageCall,
+		Content: []byte(`{"helper":true,"requirements":["de"]}`),
+	})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(helperConn, outgoingMessageCallAssigned); err != nil {
+		t.Fatal(err)
+	}
+
+	// the helper doesn't answer, the offer expires on the next queue check
+	msg, err := testExpectMessage(helperConn, outgoingMessageOfferWithdrawn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var withdrawn offerWithdrawnPayload
+	if err := json.Unmarshal(msg.Content, &withdrawn); err != nil {
+		t.Fatalf("couldn't decode the withdrawn offer: %s", err)
+	}
+	if withdrawn.Reason != offerTimeout {
+		t.Errorf("the offer expected to be withdrawn on the timeout, got '%s'", withdrawn.Reason)
+	}
+
+	msg, err = testExpectMessage(helperConn, outgoingMessageHelperState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state helperStatePayload
+	if err := json.Unmarshal(msg.Content, &state); err != nil {
+		t.Fatalf("couldn't decode the helper state: %s", err)
+	}
+	if state.State != helperStateOnBreak {
+		t.Errorf("the helper who hasn't answered expected to be on break, got '%s'", state.State)
+	}
+
+	if _, err := testExpectMessage(supervisorConn, outgoingMessageCallAssigned); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case escalation := <-apiClient.escalations:
+		if escalation.Offers != 1 {
+			t.Errorf("the escalation after a single offer expected, got %+v", escalation)
+		}
+	case <-time.After(time.Second):
+		t.Error("the API expected to be notified about the escalation")
+	}
+}
+
+func TestHub_escalate_to_allowed_supervisors(t *testing.T) {
+	apiClient := &escalationApiClientStub{escalations: make(chan *api.EscalationRequest, 1)}
+	h := newTestHub(t, apiClient, Options{Supervisors: []string{"supervisor"}, Queue: QueueOptions{MaxOffers: 1}})
+	callerConn := h.connect("")
+	helperConn := h.connect("helper")
+	impostorConn := h.connect("impostor")
+	supervisorConn := h.connect("supervisor")
+
+	// the supervisor is online but on break, the escalated call waits for them
+	testSendMessage(supervisorConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"on_break"}`)})
+	testSendMessage(impostorConn, connectionMessage{
+		Typ:     incomingMessageHelperStatus,
+		Content: []byte(`{"status":"available","skills":["supervisor"]}`),
+	})
+
+	pairID := testDeclineQueuedCall(t, callerConn, helperConn)
+
+	if _, err := testExpectMessage(impostorConn, outgoingMessageCallAssigned); err == nil {
+		t.Fatal("the escalated call isn't expected to be assigned to a client declaring the supervisor skill")
+	}
+
+	testSendMessage(supervisorConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+	msg, err := testExpectMessage(supervisorConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+	if assigned.PairID != pairID {
+		t.Errorf("the escalated call %s expected, got %s", pairID, assigned.PairID)
+	}
+}
+
+func TestHub_escalate_without_supervisors_online(t *testing.T) {
+	apiClient := &escalationApiClientStub{escalations: make(chan *api.EscalationRequest, 1)}
+	h := newTestHub(t, apiClient, Options{Supervisors: []string{"supervisor"}, Queue: QueueOptions{MaxOffers: 1}})
+	callerConn := h.connect("")
+	helperConn := h.connect("helper")
+
+	testDeclineQueuedCall(t, callerConn, helperConn)
+
+	// the escalated call is routed like the other calls while no supervisor is online
+	nextHelperConn := h.connect("next")
+	testSendMessage(nextHelperConn, connectionMessage{
+		Typ:     incomingMessageHelperStatus,
+		Content: []byte(`{"status":"available","skills":["de"]}`),
+	})
+	if _, err := testExpectMessage(nextHelperConn, outgoingMessageCallAssigned); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testDeclineQueuedCall queues a call requiring the skill of the helper who declines it, it returns the pair of the call
+func testDeclineQueuedCall(t *testing.T, callerConn, helperConn *webSocketConnStub) uuid.UUID {
+	testSendMessage(helperConn, connectionMessage{
+		Typ:     incomingMessageHelperStatus,
+		Content: []byte(`{"status":"available","skills":["de"]}`),
+	})
+	testSendMessage(callerConn, connectionMessage{
+		Typ:     incomingMessageCall,
+		Content: []byte(`{"helper":true,"requirements":["de"]}`),
+	})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := testExpectMessage(helperConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageCallDeclined, Content: assigned.PairID[:]})
+	if _, err := testExpectMessage(helperConn, outgoingMessageOfferWithdrawn); err != nil {
+		t.Fatal(err)
+	}
+
+	return assigned.PairID
+}
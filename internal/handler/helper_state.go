@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The states of a helper, the calls are assigned only to the available helpers
+const (
+	helperStateAvailable = "available"
+	helperStateBusy      = "busy"
+	helperStateOnBreak   = "on_break"
+	helperStateWrapUp    = "wrap_up"
+)
+
+var helperStates = []string{helperStateAvailable, helperStateBusy, helperStateOnBreak, helperStateWrapUp}
+
+// helperStatePayload is the content of the message telling the helper about the change of their state
+type helperStatePayload struct {
+	State string `json:"state"`
+	// Since is the time the helper has entered the state
+	Since      time.Time  `json:"since"`
+	WrapUpEnds *time.Time `json:"wrap_up_ends,omitempty"`
+}
+
+// HelperInfo describes a helper registered in the hub
+type HelperInfo struct {
+	ConnID     uuid.UUID  `json:"conn_id"`
+	Subject    string     `json:"subject,omitempty"`
+	RemoteAddr string     `json:"remote_addr"`
+	State      string     `json:"state"`
+	Since      time.Time  `json:"since"`
+	WrapUpEnds *time.Time `json:"wrap_up_ends,omitempty"`
+	Skills     []string   `json:"skills"`
+	PairID     *uuid.UUID `json:"pair_id,omitempty"`
+}
+
+// setHelperState moves the helper to a given state and notifies them about it
+func (h *hub) setHelperState(c *client, state *helperState, newState string) {
+	c.logger().WithFields(log.Fields{
+		"state":          newState,
+		"previous_state": state.state,
+	}).Debug("the helper state has been changed")
+
+	state.state = newState
+	state.since = time.Now()
+	if newState != helperStateWrapUp {
+		state.wrapUpEnds = time.Time{}
+	}
+
+	h.notifyHelperState(c, state)
+	h.updateHelperMetrics()
+}
+
+// notifyHelperState posts the current state to the helper
+func (h *hub) notifyHelperState(c *client, state *helperState) {
+	payload := &helperStatePayload{State: state.state, Since: state.since}
+	if state.state == helperStateWrapUp {
+		wrapUpEnds := state.wrapUpEnds
+		payload.WrapUpEnds = &wrapUpEnds
+	}
+
+	content, err := json.Marshal(payload)
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the helper state payload")
+		return
+	}
+
+	c.post(connectionMessage{Typ: outgoingMessageHelperState, Content: content})
+}
+
+// endWrapUps makes the helpers whose wrap-up has ended available
+func (h *hub) endWrapUps() {
+	now := time.Now()
+	for c, state := range h.helpers {
+		if state.state == helperStateWrapUp && !now.Before(state.wrapUpEnds) {
+			h.setHelperState(c, state, helperStateAvailable)
+		}
+	}
+}
+
+func (h *hub) updateHelperMetrics() {
+	counts := make(map[string]int, len(helperStates))
+	for _, state := range h.helpers {
+		counts[state.state]++
+	}
+
+	for _, state := range helperStates {
+		metrics.Helpers.WithLabelValues(state).Set(float64(counts[state]))
+	}
+}
+
+func (h *hub) helperInfos() []*HelperInfo {
+	helpers := make([]*HelperInfo, 0, len(h.helpers))
+	for c, state := range h.helpers {
+		info := &HelperInfo{
+			ConnID:     c.id,
+			Subject:    c.subject,
+			RemoteAddr: c.conn.RemoteAddr().String(),
+			State:      state.state,
+			Since:      state.since,
+			Skills:     state.skillList(),
+		}
+
+		if state.state == helperStateWrapUp {
+			wrapUpEnds := state.wrapUpEnds
+			info.WrapUpEnds = &wrapUpEnds
+		}
+
+		if state.assigned != nil {
+			pairID := state.assigned.pair.id
+			info.PairID = &pairID
+		}
+
+		if state.monitoring != nil {
+			pairID := state.monitoring.id
+			info.PairID = &pairID
+		}
+
+		helpers = append(helpers, info)
+	}
+
+	return helpers
+}
+
+// Helpers returns the helpers registered in the hub
+func (s *Server) Helpers(ctx context.Context) ([]*HelperInfo, error) {
+	result := make(chan []*HelperInfo, 1)
+
+	select {
+	case s.hub.listHelpers <- result:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "the hub didn't respond")
+	}
+
+	return <-result, nil
+}
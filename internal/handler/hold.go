@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// holdRequest is sent to the pair by a client putting the other side on hold or resuming the call held by them
+type holdRequest struct {
+	client *client
+	resume bool
+}
+
+// heldPayload is the content of the message telling a client the other side has put them on hold,
+// the message is the on hold notification configured on the server
+type heldPayload struct {
+	PairID  uuid.UUID `json:"pair_id"`
+	Message string    `json:"message,omitempty"`
+}
+
+// resumedPayload is the content of the message telling the held client the call has been resumed
+type resumedPayload struct {
+	PairID  uuid.UUID `json:"pair_id"`
+	HeldFor float64   `json:"held_seconds"`
+}
+
+// setHold puts the other side of the answered pair on hold or resumes the call held by the client
+func (p *pair) setHold(request *holdRequest) {
+	c := request.client
+	other := p.otherClient(c)
+	if other == nil {
+		c.reportError(messageHandleError{
+			Code: errorCodeHold,
+			Desc: "Only the clients of an answered call could hold it",
+		})
+		return
+	}
+
+	if request.resume {
+		p.resume(c, other)
+		return
+	}
+
+	if p.heldBy != nil {
+		c.reportError(messageHandleError{
+			Code: errorCodeHold,
+			Desc: "The call is already on hold",
+		})
+		return
+	}
+
+	p.mu.Lock()
+	p.heldBy = c
+	p.heldAt = time.Now()
+	p.mu.Unlock()
+	c.logger().WithField("pair_id", p.id).Info("the call has been put on hold")
+
+	content, err := json.Marshal(&heldPayload{PairID: p.id, Message: p.holdMessage})
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the held payload")
+		return
+	}
+	other.notify(connectionMessage{Typ: outgoingMessageHeld, Content: content})
+}
+
+// resume ends the hold the client has put the other side on
+func (p *pair) resume(c *client, other *client) {
+	if p.heldBy != c {
+		c.reportError(messageHandleError{
+			Code: errorCodeHold,
+			Desc: "The call isn't held by the client",
+		})
+		return
+	}
+
+	held := p.endHold()
+	c.logger().WithFields(log.Fields{
+		"pair_id":  p.id,
+		"held_for": held.String(),
+	}).Info("the call has been resumed")
+
+	content, err := json.Marshal(&resumedPayload{PairID: p.id, HeldFor: held.Seconds()})
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the resumed payload")
+		return
+	}
+	other.notify(connectionMessage{Typ: outgoingMessageResumed, Content: content})
+}
+
+// endHold ends the ongoing hold adding it to the held duration of the pair, it returns the duration of the ended hold
+func (p *pair) endHold() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.heldBy == nil {
+		return 0
+	}
+
+	held := time.Since(p.heldAt)
+	p.heldDuration += held
+	p.heldBy = nil
+	metrics.HoldDuration.Observe(held.Seconds())
+
+	return held
+}
+
+// heldFor returns the time the pair has been held for including the ongoing hold, it's called under the lock
+func (p *pair) heldFor() time.Duration {
+	if p.heldBy == nil {
+		return p.heldDuration
+	}
+
+	return p.heldDuration + time.Since(p.heldAt)
+}
+
+// otherClient returns the other client of the answered pair or nil if the client isn't its caller or callee
+func (p *pair) otherClient(c *client) *client {
+	if p.clients[0] == nil || p.clients[1] == nil {
+		return nil
+	}
+
+	switch c {
+	case p.clients[0]:
+		return p.clients[1]
+	case p.clients[1]:
+		return p.clients[0]
+	default:
+		return nil
+	}
+}
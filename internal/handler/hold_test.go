@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPair_hold_and_resume(t *testing.T) {
+	h := newTestHub(t, nil, Options{HoldMessage: "Please hold the line"})
+	callerConn := h.connect("")
+	calleeConn := h.connect("bob")
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessagePresence, Content: []byte("bob")})
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"callee":"bob"}`)})
+
+	msg, err := testExpectMessage(calleeConn, outgoingMessageIncomingCall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var incoming incomingCallPayload
+	if err := json.Unmarshal(msg.Content, &incoming); err != nil {
+		t.Fatalf("couldn't decode the incoming call: %s", err)
+	}
+
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessageAnswer, Content: incoming.PairID[:]})
+	if _, err := testExpectMessage(calleeConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessageHold})
+
+	msg, err = testExpectMessage(callerConn, outgoingMessageHeld)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var held heldPayload
+	if err := json.Unmarshal(msg.Content, &held); err != nil {
+		t.Fatalf("couldn't decode the held message: %s", err)
+	}
+	if held.PairID != incoming.PairID || held.Message != "Please hold the line" {
+		t.Errorf("unexpected held message: %+v", held)
+	}
+
+	// only the client which has put the call on hold could resume it
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageResume})
+	if _, err := testExpectMessage(callerConn, outgoingMessageError); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessageResume})
+	if _, err := testExpectMessage(callerConn, outgoingMessageResumed); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,77 +1,353 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"bitbucket.org/stop-panic/signaling/internal/sdp"
 	"github.com/google/uuid"
-	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type pairInfo struct {
+	ctx    context.Context
 	client *client
 	pairID uuid.UUID
 }
 
+type registration struct {
+	ctx    context.Context
+	client *client
+	call   *callRequest
+}
+
+// callEndedPayload is the content of the message telling a client kept connected that its call has ended,
+// the reason is set for the pairs terminated by an administrator
+type callEndedPayload struct {
+	PairID uuid.UUID           `json:"pair_id"`
+	Reason *messageHandleError `json:"reason,omitempty"`
+}
+
 type hub struct {
-	pairs      map[uuid.UUID]*pair
-	pair       chan *pairInfo
-	register   chan *client
-	unregister chan *pair
+	pairs         map[uuid.UUID]*pair
+	pair          chan *pairInfo
+	register      chan *registration
+	unregister    chan *pair
+	ping          chan struct{}
+	list          chan chan []*PairInfo
+	find          chan *pairQuery
+	terminatePair chan *pairTermination
+	// users are the online devices by the IDs of the users they've registered as
+	users    map[string]map[*client]struct{}
+	online   chan *presence
+	offline  chan *presence
+	findUser chan *presenceQuery
+	// queue is the FIFO queue of the calls waiting for a helper
+	queue        []*queuedCall
+	helpers      map[*client]*helperState
+	enqueue      chan *queuedCall
+	helperStatus chan *helperStatus
+	decline      chan *offerDecline
+	listHelpers  chan chan []*HelperInfo
+	monitor      chan *monitorRequest
+	transfer     chan *transferRequest
+	transferred  chan *transferCompletion
+	// answered are the helpers who have answered a call, the call is withdrawn from the other helpers it's offered to
+	answered chan *client
+	// callbacks are the requests of the callers to be called back ordered like the queue
+	callbacks        []*Callback
+	callbackRequests chan *callbackRequest
+	callbackClaims   chan *callbackClaim
+	listCallbacks    chan chan []*Callback
+	// abandoned are the latest calls the callers have hung up while waiting for a helper, the oldest one goes first
+	abandoned     []*AbandonedCall
+	listAbandoned chan chan []*AbandonedCall
+	// api is notified about the escalated calls
+	api          ApiClient
+	pairCapacity *capacity
+	// relayOnly forces the privacy mode for all the pairs
+	relayOnly    bool
+	sdpPolicy    *sdp.Policy
+	queueOptions QueueOptions
+	holdMessage  string
+	// supervisors are the subjects of the clients allowed to monitor the calls
+	supervisors map[string]bool
+	// handleTimes are the durations of the latest calls handled by the helpers, the oldest one goes first
+	handleTimes []time.Duration
 }
 
-func newHub() *hub {
+func newHub(pairCapacity *capacity, apiClient ApiClient, options Options) *hub {
+	supervisors := make(map[string]bool, len(options.Supervisors))
+	for _, subject := range options.Supervisors {
+		supervisors[subject] = true
+	}
+
 	return &hub{
-		pairs:      make(map[uuid.UUID]*pair),
-		pair:       make(chan *pairInfo),
-		register:   make(chan *client),
-		unregister: make(chan *pair),
+		pairCapacity:     pairCapacity,
+		api:              apiClient,
+		relayOnly:        options.RelayOnly,
+		sdpPolicy:        options.SdpPolicy,
+		queueOptions:     options.Queue,
+		holdMessage:      options.HoldMessage,
+		supervisors:      supervisors,
+		pairs:            make(map[uuid.UUID]*pair),
+		pair:             make(chan *pairInfo),
+		register:         make(chan *registration),
+		unregister:       make(chan *pair),
+		ping:             make(chan struct{}),
+		list:             make(chan chan []*PairInfo),
+		find:             make(chan *pairQuery),
+		terminatePair:    make(chan *pairTermination),
+		users:            make(map[string]map[*client]struct{}),
+		online:           make(chan *presence),
+		offline:          make(chan *presence),
+		findUser:         make(chan *presenceQuery),
+		helpers:          make(map[*client]*helperState),
+		enqueue:          make(chan *queuedCall),
+		helperStatus:     make(chan *helperStatus),
+		decline:          make(chan *offerDecline),
+		listHelpers:      make(chan chan []*HelperInfo),
+		monitor:          make(chan *monitorRequest),
+		transfer:         make(chan *transferRequest),
+		transferred:      make(chan *transferCompletion),
+		answered:         make(chan *client),
+		callbackRequests: make(chan *callbackRequest),
+		callbackClaims:   make(chan *callbackClaim),
+		listCallbacks:    make(chan chan []*Callback),
+		listAbandoned:    make(chan chan []*AbandonedCall),
 	}
 }
 
 func (h *hub) run() {
+	var queueStatus <-chan time.Time
+	if h.queueOptions.StatusInterval > 0 {
+		ticker := time.NewTicker(h.queueOptions.StatusInterval)
+		defer ticker.Stop()
+		queueStatus = ticker.C
+	}
+
+	var queueCheck <-chan time.Time
+	if h.queueOptions.RelaxAfter > 0 || h.queueOptions.OfferTimeout > 0 || h.queueOptions.WrapUp > 0 || h.isFallbackEnabled() {
+		ticker := time.NewTicker(queueCheckInterval)
+		defer ticker.Stop()
+		queueCheck = ticker.C
+	}
+
 	for {
 		select {
 		case clientPair := <-h.pair:
-			p, err := h.findPair(clientPair.pairID)
+			p, err := h.tracedFindPair(clientPair.ctx, clientPair.pairID)
 
 			if err != nil {
-				clientPair.client.messageHandleErrors <- messageHandleError{
+				clientPair.client.logger().WithError(err).WithField("pair_id", clientPair.pairID).
+					Debug("couldn't find a pair to answer")
+				clientPair.client.failPairing(messageHandleError{
 					Code: errorCodePairID,
 					Desc: "Couldn't find a peer to connect",
-				}
+				})
 			}
 
 			if err == nil {
 				p.pairing <- clientPair.client
 			}
-		case c := <-h.register:
-			log.Debug("client registration request sent to the hub")
-			p, err := newPair()
+		case reg := <-h.register:
+			c := reg.client
+			ctx, span := tracer.Start(reg.ctx, "hub.register")
+			c.logger().Debug("client registration request sent to the hub")
+
+			if h.pairCapacity.isFull() {
+				c.logger().Warn("couldn't register a client, the maximum number of pairs is reached")
+				c.failPairing(messageHandleError{
+					Code: errorCodeCapacity,
+					Desc: "The server is at capacity, try again later",
+				})
+				span.End()
+				continue
+			}
+
+			p, err := newPair(ctx, reg.call, h.relayOnly || reg.call.Privacy, h.sdpPolicy, h.holdMessage)
 			if err != nil {
-				log.WithError(err).Debug("couldn't register a client")
-				c.messageHandleErrors <- messageHandleError{
+				c.logger().WithError(err).Debug("couldn't register a client")
+				c.failPairing(messageHandleError{
 					Code: errorCodeCall,
 					Desc: "Couldn't find a peer to connect",
-				}
+				})
 			}
 
 			if err == nil {
 				go p.run()
-				log.Debug("a new pair for registered client created and run")
+				logger := c.logger().WithField("pair_id", p.id)
+				logger.Debug("a new pair for registered client created and run")
 				h.pairs[p.id] = p
-				log.Debug("sending a client to a pair")
+				h.updatePairCount()
+				logger.Debug("sending a client to a pair")
 				p.pairing <- c
-				log.Debug("client has been successfully sent to the pair")
+				logger.Debug("client has been successfully sent to the pair")
 			}
+			span.End()
 		case p := <-h.unregister:
-			delete(h.pairs, p.id)
+			// both clients of a pair unregister it, so the pair is terminated only once
+			if _, ok := h.pairs[p.id]; ok {
+				h.removePair(p, nil)
+			}
+		case <-h.ping:
+		case result := <-h.list:
+			pairs := make([]*PairInfo, 0, len(h.pairs))
+			for _, p := range h.pairs {
+				pairs = append(pairs, p.info())
+			}
+			result <- pairs
+		case query := <-h.find:
+			p, err := h.findPair(query.pairID)
+			if err != nil {
+				query.result <- nil
+				continue
+			}
+			query.result <- p.info()
+		case termination := <-h.terminatePair:
+			p, err := h.findPair(termination.pairID)
+			if err != nil {
+				termination.result <- err
+				continue
+			}
+			log.WithField("pair_id", p.id).Info("the pair has been terminated by an administrator")
+			h.removePair(p, &termination.reason)
+			termination.result <- nil
+		case online := <-h.online:
+			devices, ok := h.users[online.userID]
+			if !ok {
+				devices = make(map[*client]struct{})
+				h.users[online.userID] = devices
+			}
+			devices[online.client] = struct{}{}
+			metrics.OnlineUsers.Set(float64(len(h.users)))
+		case offline := <-h.offline:
+			devices := h.users[offline.userID]
+			delete(devices, offline.client)
+			if len(devices) == 0 {
+				delete(h.users, offline.userID)
+			}
+			metrics.OnlineUsers.Set(float64(len(h.users)))
+		case call := <-h.enqueue:
+			h.enqueueCall(call)
+		case status := <-h.helperStatus:
+			h.setHelperStatus(status)
+		case <-queueStatus:
+			h.sendQueueStatus()
+		case <-queueCheck:
+			h.expireOffers()
+			h.endWrapUps()
+			h.distribute()
+			h.sendFallbacks()
+		case decline := <-h.decline:
+			h.declineOffer(decline)
+		case result := <-h.listHelpers:
+			result <- h.helperInfos()
+		case request := <-h.monitor:
+			h.monitorPair(request)
+		case request := <-h.transfer:
+			h.transferCall(request)
+		case completion := <-h.transferred:
+			h.releaseTransferred(completion)
+		case helper := <-h.answered:
+			h.withdrawAnsweredOffers(helper)
+		case request := <-h.callbackRequests:
+			h.registerCallback(request)
+		case claim := <-h.callbackClaims:
+			h.claimCallback(claim)
+		case result := <-h.listCallbacks:
+			callbacks := make([]*Callback, 0, len(h.callbacks))
+			for _, callback := range h.callbacks {
+				info := *callback
+				callbacks = append(callbacks, &info)
+			}
+			result <- callbacks
+		case result := <-h.listAbandoned:
+			abandoned := make([]*AbandonedCall, 0, len(h.abandoned))
+			for _, call := range h.abandoned {
+				info := *call
+				abandoned = append(abandoned, &info)
+			}
+			result <- abandoned
+		case query := <-h.findUser:
+			devices := make([]*client, 0, len(h.users[query.userID]))
+			for device := range h.users[query.userID] {
+				devices = append(devices, device)
+			}
+			query.result <- devices
+		}
+	}
+}
+
+// removePair removes the pair from the hub and terminates it sending the reason to its clients
+func (h *hub) removePair(p *pair, reason *messageHandleError) {
+	delete(h.pairs, p.id)
+	h.updatePairCount()
+
+	// the helpers and the supervisors stay connected to take the next calls
+	var helpers []*client
+	for c := range h.helpers {
+		if p.isMember(c) {
+			p.release(c)
+			helpers = append(helpers, c)
 		}
 	}
+
+	p.reason = reason
+	close(p.terminate)
+
+	// the pairs terminated by an administrator aren't abandoned by their callers
+	if reason == nil {
+		h.recordAbandoned(p)
+	}
+
+	for _, c := range helpers {
+		h.notifyCallEnded(c, p, reason)
+	}
+	h.releaseHelper(p)
+}
+
+// notifyCallEnded tells the client released from the removed pair that the call has ended
+func (h *hub) notifyCallEnded(c *client, p *pair, reason *messageHandleError) {
+	content, err := json.Marshal(&callEndedPayload{PairID: p.id, Reason: reason})
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the call ended payload")
+		return
+	}
+
+	c.post(connectionMessage{Typ: outgoingMessageCallEnded, Content: content})
+}
+
+func (h *hub) updatePairCount() {
+	metrics.ActivePairs.Set(float64(len(h.pairs)))
+	h.pairCapacity.set(len(h.pairs))
+}
+
+// tracedFindPair finds a pair linking the span of the search to the call setup trace of the found pair
+func (h *hub) tracedFindPair(ctx context.Context, pairID uuid.UUID) (*pair, error) {
+	_, span := tracer.Start(ctx, "hub.findPair")
+	defer span.End()
+	span.SetAttributes(attribute.String("pair_id", pairID.String()))
+
+	p, err := h.findPair(pairID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.AddLink(trace.Link{SpanContext: p.spanContext})
+
+	return p, nil
 }
 
 func (h *hub) findPair(pairID uuid.UUID) (*pair, error) {
 	pair, ok := h.pairs[pairID]
 	if !ok {
-		return nil, errors.New("the pair with a given ID wasn't found in the hub")
+		return nil, ErrPairNotFound
 	}
 
 	return pair, nil
@@ -1,16 +1,19 @@
 package handler
 
 import (
+	"context"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/google/uuid"
+	"bitbucket.org/stop-panic/signaling/internal/api"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 )
 
 func TestHub_successful_pairing(t *testing.T) {
-	h := newHub()
+	h := newHub(nil, nil, Options{})
 
 	t.Log("running a hub")
 	go h.run()
@@ -22,8 +25,8 @@ func TestHub_successful_pairing(t *testing.T) {
 	apiClient1 := newApiClientStub(conn2)
 	apiClient2 := newApiClientStub(conn1)
 
-	client1 := newClient(conn1, h, apiClient1)
-	client2 := newClient(conn2, h, apiClient2)
+	client1 := newClient(conn1, h, apiClient1, nil, nil, connectionInfo{})
+	client2 := newClient(conn2, h, apiClient2, nil, nil, connectionInfo{})
 
 	t.Log("running clients")
 	go client1.run()
@@ -55,6 +58,28 @@ func TestHub_successful_pairing(t *testing.T) {
 	}
 }
 
+func TestClient_post_in_order(t *testing.T) {
+	conn := newWebSocketConnStub()
+	c := newClient(conn, newHub(nil, nil, Options{}), nil, nil, nil, connectionInfo{})
+
+	expected := []MessageType{outgoingMessageHelperState, outgoingMessageCallAssigned, outgoingMessageOfferWithdrawn}
+	for _, typ := range expected {
+		c.post(connectionMessage{Typ: typ})
+	}
+	go c.run()
+
+	for _, typ := range expected {
+		select {
+		case wsMsg := <-conn.out:
+			if MessageType(wsMsg.data[0]) != typ {
+				t.Fatalf("the %s message expected, got %s", typ, MessageType(wsMsg.data[0]))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("haven't got the %s message", typ)
+		}
+	}
+}
+
 func testExpectedCallInitConfirmationMessage(conn *webSocketConnStub, result chan error) {
 	timeout := time.Second
 	timer := time.NewTimer(timeout)
@@ -62,7 +87,7 @@ func testExpectedCallInitConfirmationMessage(conn *webSocketConnStub, result cha
 	for {
 		select {
 		case wsMsg := <-conn.out:
-			connMsg, err := newConnectionMessageFromBytes(wsMsg.data)
+			connMsg, err := newConnectionMessageFromBytes(wsMsg.data, nil)
 			if err != nil {
 				result <- err
 			}
@@ -99,7 +124,9 @@ type webSocketConnStub struct {
 		typ  int
 		data []byte
 	}
-	isClosed bool
+	// isClosed is read and set by the reader and the writer of the client, closed releases a blocked write
+	isClosed atomic.Bool
+	closed   chan struct{}
 }
 
 func newWebSocketConnStub() *webSocketConnStub {
@@ -112,12 +139,12 @@ func newWebSocketConnStub() *webSocketConnStub {
 			typ  int
 			data []byte
 		}),
-		isClosed: false,
+		closed: make(chan struct{}),
 	}
 }
 
 func (c *webSocketConnStub) ReadMessage() (messageType int, p []byte, err error) {
-	if c.isClosed {
+	if c.isClosed.Load() {
 		return 0, nil, &websocket.CloseError{
 			Code: websocket.CloseNormalClosure,
 			Text: "Connection is closed",
@@ -133,23 +160,29 @@ func (c *webSocketConnStub) ReadMessage() (messageType int, p []byte, err error)
 }
 
 func (c *webSocketConnStub) WriteMessage(messageType int, data []byte) error {
-	if c.isClosed {
+	if c.isClosed.Load() {
 		return &websocket.CloseError{
 			Code: websocket.CloseNormalClosure,
 			Text: "Connection is closed",
 		}
 	}
 
-	c.out <- struct {
+	select {
+	case c.out <- struct {
 		typ  int
 		data []byte
-	}{typ: messageType, data: data}
-
-	return nil
+	}{typ: messageType, data: data}:
+		return nil
+	case <-c.closed:
+		return &websocket.CloseError{
+			Code: websocket.CloseNormalClosure,
+			Text: "Connection is closed",
+		}
+	}
 }
 
 func (c *webSocketConnStub) SetWriteDeadline(t time.Time) error {
-	if c.isClosed {
+	if c.isClosed.Load() {
 		return &websocket.CloseError{
 			Code: websocket.CloseNormalClosure,
 			Text: "Connection is closed",
@@ -158,8 +191,87 @@ func (c *webSocketConnStub) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
+func (c *webSocketConnStub) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+// Close closes the connection either on the server side or on the peer side, e.g. the caller hanging up
 func (c *webSocketConnStub) Close() error {
-	c.isClosed = true
+	if c.isClosed.CompareAndSwap(false, true) {
+		close(c.closed)
+	}
+	return nil
+}
+
+func testSendMessage(conn *webSocketConnStub, msg connectionMessage) {
+	conn.in <- struct {
+		typ  int
+		data []byte
+	}{typ: websocket.BinaryMessage, data: msg.Encode()}
+}
+
+// testExpectMessage waits for a message of a given type skipping the others,
+// the timeout lets the messages sent on the queue checks arrive
+func testExpectMessage(conn *webSocketConnStub, typ MessageType) (*connectionMessage, error) {
+	timeout := 2 * queueCheckInterval
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case wsMsg := <-conn.out:
+			connMsg, err := newConnectionMessageFromBytes(wsMsg.data, nil)
+			if err != nil {
+				continue
+			}
+
+			if connMsg.Typ == typ {
+				return connMsg, nil
+			}
+		case <-timer.C:
+			return nil, errors.Errorf("haven't got a %s message in %v", typ, timeout)
+		}
+	}
+}
+
+// testHub is a running hub the clients of a test connect to over the stub connections
+type testHub struct {
+	*hub
+	api ApiClient
+}
+
+// newTestHub runs a hub notifying a given API about the escalations,
+// the clients fail the test on calling the API unless it's given
+func newTestHub(t *testing.T, apiClient ApiClient, options Options) *testHub {
+	h := newHub(nil, apiClient, options)
+	go h.run()
+
+	if apiClient == nil {
+		apiClient = &failingApiClientStub{t: t}
+	}
+
+	return &testHub{hub: h, api: apiClient}
+}
+
+// connect connects a client authenticated as a given subject, an empty subject connects an anonymous client
+func (h *testHub) connect(subject string) *webSocketConnStub {
+	conn := newWebSocketConnStub()
+	go newClient(conn, h.hub, h.api, nil, nil, connectionInfo{subject: subject}).run()
+
+	return conn
+}
+
+type failingApiClientStub struct {
+	t *testing.T
+}
+
+func (c *failingApiClientStub) Call(_ context.Context, call *api.CallRequest) error {
+	c.t.Errorf("the API isn't expected to be called for the online callee %s", call.Callee)
+	return nil
+}
+
+func (c *failingApiClientStub) Escalate(_ context.Context, escalation *api.EscalationRequest) error {
+	c.t.Errorf("the API isn't expected to be notified about the escalation of %s", escalation.PairID)
 	return nil
 }
 
@@ -171,10 +283,10 @@ func newApiClientStub(conn *webSocketConnStub) *apiClientStub {
 	return &apiClientStub{peerWebSocketConn: conn}
 }
 
-func (c *apiClientStub) Call(pairID uuid.UUID) error {
+func (c *apiClientStub) Call(_ context.Context, call *api.CallRequest) error {
 	msg := &connectionMessage{
 		Typ:     incomingMessageAnswer,
-		Content: pairID[:],
+		Content: call.PairID[:],
 	}
 
 	c.peerWebSocketConn.in <- struct {
@@ -184,3 +296,7 @@ func (c *apiClientStub) Call(pairID uuid.UUID) error {
 
 	return nil
 }
+
+func (c *apiClientStub) Escalate(_ context.Context, _ *api.EscalationRequest) error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/turn"
+)
+
+const defaultTurnTTL = 24 * time.Hour
+
+// IceServers configures the STUN and TURN servers advertised to the clients
+type IceServers struct {
+	StunUrls []string
+	TurnUrls []string
+	// TurnSecret is shared with the TURN server to issue the ephemeral credentials, TURN isn't advertised without it
+	TurnSecret string
+	TurnTTL    time.Duration
+}
+
+// iceServer is encoded as the RTCIceServer dictionary of the WebRTC API
+type iceServer struct {
+	Urls       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// callPayload is the content of the call initialized and answer accepted messages,
+// the call is described only to the client answering it
+type callPayload struct {
+	IceServers []iceServer   `json:"ice_servers"`
+	Call       *callMetadata `json:"call,omitempty"`
+}
+
+// iceServersFor returns the ICE servers with the TURN credentials issued to a given user
+func (s *IceServers) iceServersFor(user string) []iceServer {
+	if s == nil {
+		return []iceServer{}
+	}
+
+	servers := make([]iceServer, 0, 2)
+
+	if len(s.StunUrls) > 0 {
+		servers = append(servers, iceServer{Urls: s.StunUrls})
+	}
+
+	if len(s.TurnUrls) > 0 && s.TurnSecret != "" {
+		ttl := s.TurnTTL
+		if ttl <= 0 {
+			ttl = defaultTurnTTL
+		}
+
+		credentials := turn.NewCredentials(s.TurnSecret, user, ttl, time.Now())
+		servers = append(servers, iceServer{
+			Urls:       s.TurnUrls,
+			Username:   credentials.Username,
+			Credential: credentials.Password,
+		})
+	}
+
+	return servers
+}
+
+// encodeCallPayload returns the content of the call setup messages sent to the client
+func encodeCallPayload(c *client, call *callMetadata) ([]byte, error) {
+	user := c.subject
+	if user == "" {
+		user = c.id.String()
+	}
+
+	return json.Marshal(&callPayload{IceServers: c.iceServers.iceServersFor(user), Call: call})
+}
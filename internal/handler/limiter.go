@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"net"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"bitbucket.org/stop-panic/signaling/internal/ratelimit"
+	"golang.org/x/time/rate"
+)
+
+const (
+	limitUpgrade   = "upgrade"
+	limitCall      = "call"
+	limitSignaling = "signaling"
+)
+
+// MessageSizeLimits configures the maximum sizes of the incoming messages in bytes, a zero size isn't limited
+type MessageSizeLimits struct {
+	// MaxMessageSize is the read limit of the connection, the connection is closed if a message exceeds it
+	MaxMessageSize int64
+	// The limits of the content of the messages by type, the sender gets an error if the content exceeds them
+	Signaling int
+	Call      int
+	Answer    int
+	// Control is the limit of the content of the other messages, e.g. the presence, the helper status,
+	// the transfer or the callback requests
+	Control int
+}
+
+// controlMessages are the incoming messages carrying short requests, their content is limited by the control size
+var controlMessages = []MessageType{
+	incomingMessagePresence,
+	incomingMessageHelperStatus,
+	incomingMessageCallDeclined,
+	incomingMessageMonitor,
+	incomingMessageBargeIn,
+	incomingMessageTransfer,
+	incomingMessageHold,
+	incomingMessageResume,
+	incomingMessageCallbackRequest,
+	incomingMessageCallbackClaim,
+}
+
+// RateLimits configures the token bucket limits, a limit is disabled if its rate is zero
+type RateLimits struct {
+	// UpgradeRate is the number of connection upgrades allowed per second from a single IP
+	UpgradeRate  float64
+	UpgradeBurst int
+	// CallRate is the number of call initiations allowed per second for a single user, or IP if the user is unknown
+	CallRate  float64
+	CallBurst int
+	// SignalingRate is the number of signaling messages allowed per second through a single connection
+	SignalingRate  float64
+	SignalingBurst int
+	// AbuseThreshold is the number of consecutive rejected messages after which the connection is closed,
+	// the connection is never closed if it's zero
+	AbuseThreshold int
+}
+
+// limiter enforces the rate and size limits shared by all the clients of the server, a nil limiter allows everything
+type limiter struct {
+	limits         RateLimits
+	upgrades       *ratelimit.Keyed
+	calls          *ratelimit.Keyed
+	maxMessageSize int64
+	sizes          messageSizeLimits
+}
+
+func newLimiter(limits RateLimits, sizeLimits MessageSizeLimits) *limiter {
+	maxSizes := map[MessageType]int{
+		incomingMessageSignaling: sizeLimits.Signaling,
+		incomingMessageCall:      sizeLimits.Call,
+		incomingMessageAnswer:    sizeLimits.Answer,
+	}
+	for _, typ := range controlMessages {
+		maxSizes[typ] = sizeLimits.Control
+	}
+
+	sizes := messageSizeLimits{}
+	for typ, maxSize := range maxSizes {
+		if maxSize > 0 {
+			sizes[typ] = maxSize
+		}
+	}
+
+	return &limiter{
+		limits:         limits,
+		upgrades:       ratelimit.NewKeyed(limits.UpgradeRate, limits.UpgradeBurst),
+		calls:          ratelimit.NewKeyed(limits.CallRate, limits.CallBurst),
+		maxMessageSize: sizeLimits.MaxMessageSize,
+		sizes:          sizes,
+	}
+}
+
+func (l *limiter) messageSizes() messageSizeLimits {
+	if l == nil {
+		return nil
+	}
+
+	return l.sizes
+}
+
+func (l *limiter) allowUpgrade(remoteAddr string) bool {
+	if l == nil {
+		return true
+	}
+
+	return countRejection(limitUpgrade, l.upgrades.Allow(hostOf(remoteAddr)))
+}
+
+// allowCall limits the call initiations by the user subject falling back to the IP for anonymous clients
+func (l *limiter) allowCall(c *client) bool {
+	if l == nil {
+		return true
+	}
+
+	key := c.subject
+	if key == "" {
+		key = hostOf(c.conn.RemoteAddr().String())
+	}
+
+	return countRejection(limitCall, l.calls.Allow(key))
+}
+
+// newSignalingLimiter returns a limiter for the signaling messages of a single connection or nil if it's disabled
+func (l *limiter) newSignalingLimiter() *rate.Limiter {
+	if l == nil || l.limits.SignalingRate <= 0 {
+		return nil
+	}
+
+	burst := l.limits.SignalingBurst
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rate.NewLimiter(rate.Limit(l.limits.SignalingRate), burst)
+}
+
+func (l *limiter) isAbuse(violations int) bool {
+	return l != nil && l.limits.AbuseThreshold > 0 && violations >= l.limits.AbuseThreshold
+}
+
+func countRejection(limit string, allowed bool) bool {
+	if !allowed {
+		metrics.RateLimited.WithLabelValues(limit).Inc()
+	}
+
+	return allowed
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
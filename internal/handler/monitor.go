@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// The roles of the participants of a pair, the monitors are hidden from the caller and the callee
+// until they barge in as supervisors
+const (
+	roleCaller     = "caller"
+	roleCallee     = "callee"
+	roleMonitor    = "monitor"
+	roleSupervisor = "supervisor"
+)
+
+// monitorRequest is sent to the hub by a supervisor joining a pair as a hidden participant
+type monitorRequest struct {
+	client *client
+	pairID uuid.UUID
+}
+
+// participantSignalingPayload is the content of the signaling exchanged with the participants
+// joining a pair besides its two clients, it tells the role of the sender
+type participantSignalingPayload struct {
+	From      string `json:"from"`
+	Signaling string `json:"signaling"`
+}
+
+// participantPayload is the content of the messages telling the clients of a pair
+// about a participant joining or leaving the call visibly
+type participantPayload struct {
+	PairID uuid.UUID `json:"pair_id"`
+	Role   string    `json:"role"`
+}
+
+// isSupervisor tells if the client is authenticated as one of the supervisors, the skills declared by the client
+// aren't trusted
+func (h *hub) isSupervisor(c *client) bool {
+	return c.subject != "" && h.supervisors[c.subject]
+}
+
+// hasSupervisor reports whether any supervisor is registered as a helper regardless of their state
+func (h *hub) hasSupervisor() bool {
+	for c := range h.helpers {
+		if h.isSupervisor(c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// monitorPair joins the supervisor to the answered pair as a hidden participant,
+// the supervisor is busy until the pair is removed
+func (h *hub) monitorPair(request *monitorRequest) {
+	c := request.client
+	logger := c.logger().WithField("pair_id", request.pairID)
+
+	state, ok := h.helpers[c]
+	if !ok || !h.isSupervisor(c) || state.state == helperStateBusy {
+		logger.Warn("the client isn't allowed to monitor the call")
+		c.failPairing(messageHandleError{
+			Code: errorCodeMonitoring,
+			Desc: "Only the supervisors who aren't busy could monitor the calls",
+		})
+		return
+	}
+
+	p, err := h.findPair(request.pairID)
+	if err != nil || !p.isAnswered() {
+		logger.Debug("couldn't find an answered pair to monitor")
+		c.failPairing(messageHandleError{
+			Code: errorCodePairID,
+			Desc: "Couldn't find a call to monitor",
+		})
+		return
+	}
+
+	state.monitoring = p
+	h.setHelperState(c, state, helperStateBusy)
+	logger.Info("the supervisor is monitoring the call")
+
+	p.join <- c
+}
+
+// role returns the role of the client in the pair, it's called by the pair's goroutine or under its lock
+func (p *pair) role(c *client) string {
+	switch c {
+	case p.clients[0]:
+		return roleCaller
+	case p.clients[1]:
+		return roleCallee
+	default:
+		return p.participants[c]
+	}
+}
+
+// isMember reports whether the client is either a client or a participant of the pair
+func (p *pair) isMember(c *client) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.role(c) != ""
+}
+
+// isClient reports whether the client is either the caller or the callee of the pair
+func (p *pair) isClient(c *client) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return c == p.clients[0] || c == p.clients[1]
+}
+
+func (p *pair) addMonitor(c *client) {
+	p.mu.Lock()
+	p.participants[c] = roleMonitor
+	p.mu.Unlock()
+
+	c.setPair <- p
+}
+
+// bargeIn makes the monitoring supervisor visible to the clients of the pair,
+// the signaling of the supervisor is relayed to them from then on
+func (p *pair) bargeIn(c *client) {
+	if p.participants[c] != roleMonitor {
+		c.reportError(messageHandleError{
+			Code: errorCodeMonitoring,
+			Desc: "The client isn't monitoring the call",
+		})
+		return
+	}
+
+	p.mu.Lock()
+	p.participants[c] = roleSupervisor
+	p.mu.Unlock()
+
+	c.logger().WithField("pair_id", p.id).Info("the supervisor has barged in the call")
+	p.notifyParticipant(outgoingMessageParticipantJoined, roleSupervisor, c)
+}
+
+// removeParticipant removes the monitor or the supervisor who has left the pair
+func (p *pair) removeParticipant(c *client) {
+	role, ok := p.participants[c]
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	delete(p.participants, c)
+	p.mu.Unlock()
+
+	c.logger().WithFields(log.Fields{"pair_id": p.id, "role": role}).Debug("the participant has left the pair")
+	if role == roleSupervisor {
+		p.notifyParticipant(outgoingMessageParticipantLeft, role, nil)
+	}
+}
+
+// notifyParticipant tells the clients of the pair and the given participant about the visible participant
+func (p *pair) notifyParticipant(typ MessageType, role string, participant *client) {
+	content, err := json.Marshal(&participantPayload{PairID: p.id, Role: role})
+	if err != nil {
+		log.WithError(err).WithField("pair_id", p.id).Error("couldn't encode the participant payload")
+		return
+	}
+	msg := connectionMessage{Typ: typ, Content: content}
+
+	for _, c := range append(p.clients[:], participant) {
+		if c != nil {
+			c.notify(msg)
+		}
+	}
+}
+
+// relayToParticipants sends the signaling of the clients to the participants,
+// and the signaling of the supervisor who has barged in to the clients
+func (p *pair) relayToParticipants(msg *broadcast, from string) {
+	if len(p.participants) == 0 {
+		return
+	}
+
+	content, err := json.Marshal(&participantSignalingPayload{From: from, Signaling: string(msg.data)})
+	if err != nil {
+		msg.client.logger().WithError(err).Error("couldn't encode the participant signaling payload")
+		return
+	}
+	notification := connectionMessage{Typ: outgoingMessageParticipantSignaling, Content: content}
+
+	if from == roleSupervisor {
+		for _, c := range p.clients {
+			if c != nil {
+				c.notify(notification)
+			}
+		}
+		return
+	}
+
+	for c := range p.participants {
+		c.notify(notification)
+	}
+}
+
+// leavePair unregisters the pair if the client is its caller or callee, the other participants just leave it
+func (c *client) leavePair() {
+	if c.pair.isClient(c) {
+		c.hub.unregister <- c.pair
+		return
+	}
+
+	select {
+	case c.pair.leave <- c:
+	case <-c.pair.terminate:
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPair_monitor_and_barge_in(t *testing.T) {
+	h := newTestHub(t, nil, Options{Supervisors: []string{"supervisor"}})
+	callerConn, helperConn, pairID := testAnswerHelperCall(t, h)
+	supervisorConn := h.connect("supervisor")
+
+	testSendMessage(supervisorConn, connectionMessage{
+		Typ:     incomingMessageHelperStatus,
+		Content: []byte(`{"status":"available","skills":["supervisor"]}`),
+	})
+	testSendMessage(supervisorConn, connectionMessage{Typ: incomingMessageMonitor, Content: pairID[:]})
+	if _, err := testExpectMessage(supervisorConn, outgoingMessageMonitorAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageSignaling, Content: []byte(`{"type":"offer","sdp":"v=0"}`)})
+
+	msg, err := testExpectMessage(supervisorConn, outgoingMessageParticipantSignaling)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signaling participantSignalingPayload
+	if err := json.Unmarshal(msg.Content, &signaling); err != nil {
+		t.Fatalf("couldn't decode the participant signaling: %s", err)
+	}
+
+	if signaling.From != roleCaller {
+		t.Errorf("the signaling of the caller expected, got the one of '%s'", signaling.From)
+	}
+
+	testSendMessage(supervisorConn, connectionMessage{Typ: incomingMessageBargeIn})
+
+	// the caller is notified first, its writer is busy with the unread messages until then
+	for _, conn := range []*webSocketConnStub{callerConn, helperConn, supervisorConn} {
+		if _, err := testExpectMessage(conn, outgoingMessageParticipantJoined); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPair_monitor_declared_supervisor_rejected(t *testing.T) {
+	h := newTestHub(t, nil, Options{Supervisors: []string{"supervisor"}})
+	_, _, pairID := testAnswerHelperCall(t, h)
+	impostorConn := h.connect("impostor")
+
+	// the skill declared by the client doesn't grant the supervisor rights
+	testSendMessage(impostorConn, connectionMessage{
+		Typ:     incomingMessageHelperStatus,
+		Content: []byte(`{"status":"available","skills":["supervisor"]}`),
+	})
+	testSendMessage(impostorConn, connectionMessage{Typ: incomingMessageMonitor, Content: pairID[:]})
+
+	msg, err := testExpectMessage(impostorConn, outgoingMessageError)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handleErr messageHandleError
+	if err := json.Unmarshal(msg.Content, &handleErr); err != nil {
+		t.Fatalf("couldn't decode the error: %s", err)
+	}
+	if handleErr.Code != errorCodeMonitoring {
+		t.Errorf("the monitoring error expected, got %+v", handleErr)
+	}
+}
+
+// testAnswerHelperCall connects a caller and a helper answering the call of the caller
+func testAnswerHelperCall(t *testing.T, h *testHub) (*webSocketConnStub, *webSocketConnStub, uuid.UUID) {
+	callerConn := h.connect("")
+	helperConn := h.connect("helper")
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"helper":true}`)})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := testExpectMessage(helperConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageAnswer, Content: assigned.PairID[:]})
+	if _, err := testExpectMessage(helperConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	return callerConn, helperConn, assigned.PairID
+}
@@ -1,8 +1,17 @@
 package handler
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"bitbucket.org/stop-panic/signaling/internal/sdp"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type broadcast struct {
@@ -11,25 +20,79 @@ type broadcast struct {
 }
 
 type pair struct {
+	mu        sync.RWMutex
 	id        uuid.UUID
+	createdAt time.Time
 	clients   [2]*client
-	broadcast chan *broadcast
-	pairing   chan *client
-	terminate chan struct{}
+	// participants are the supervisors joining the pair besides its clients by their roles, it's guarded by mu
+	participants map[*client]string
+	broadcast    chan *broadcast
+	pairing      chan *client
+	join         chan *client
+	barge        chan *client
+	leave        chan *client
+	terminate    chan struct{}
+	// reason is sent to the clients on termination, it must be set before the terminate channel is closed
+	reason *messageHandleError
+	// released are the clients kept connected on termination, like the helpers or the caller waiting for a callback,
+	// they must be set before the terminate channel is closed and they're guarded by mu
+	released map[*client]bool
+	// spanContext is the context of the call setup span, the first signaling relay is traced within it
+	spanContext trace.SpanContext
+	call        callMetadata
+	callee      string
+	// ringing are the devices of the callee rung over their connections, it's guarded by mu
+	ringing []*client
+	relayed bool
+	// relayOnly enables the privacy mode removing the candidates revealing the addresses of the clients
+	relayOnly         bool
+	removedCandidates int
+	// sdpPolicy validates and rewrites the offers and answers, the signaling isn't inspected if both it and relayOnly are unset
+	sdpPolicy *sdp.Policy
+	// transfer is the pending warm transfer to another callee, it's guarded by mu
+	transfer *transfer
+	hold     chan *holdRequest
+	// holdMessage is sent to the party put on hold
+	holdMessage string
+	// heldBy is the client which has put the other one on hold, the hold fields are guarded by mu
+	heldBy       *client
+	heldAt       time.Time
+	heldDuration time.Duration
 }
 
-func newPair() (*pair, error) {
+func newPair(ctx context.Context, call *callRequest, relayOnly bool, sdpPolicy *sdp.Policy, holdMessage string) (*pair, error) {
+	_, span := tracer.Start(ctx, "newPair")
+	defer span.End()
+
 	id, err := uuid.NewRandom()
 	if err != nil {
+		span.RecordError(err)
 		return nil, errors.Wrap(err, "couldn't create a pair")
 	}
+	span.SetAttributes(
+		attribute.String("pair_id", id.String()),
+		attribute.String("media", call.Media),
+		attribute.Bool("relay_only", relayOnly),
+	)
 
 	return &pair{
-		id:        id,
-		clients:   [2]*client{nil, nil},
-		broadcast: make(chan *broadcast),
-		pairing:   make(chan *client),
-		terminate: make(chan struct{}),
+		id:           id,
+		createdAt:    time.Now(),
+		clients:      [2]*client{nil, nil},
+		participants: make(map[*client]string),
+		broadcast:    make(chan *broadcast),
+		pairing:      make(chan *client),
+		join:         make(chan *client),
+		barge:        make(chan *client),
+		leave:        make(chan *client),
+		terminate:    make(chan struct{}),
+		spanContext:  trace.SpanContextFromContext(ctx),
+		call:         call.callMetadata,
+		callee:       call.Callee,
+		relayOnly:    relayOnly,
+		sdpPolicy:    call.sdpPolicy(sdpPolicy),
+		hold:         make(chan *holdRequest),
+		holdMessage:  holdMessage,
 	}, nil
 }
 
@@ -37,33 +100,241 @@ func (p *pair) run() {
 	for {
 		select {
 		case c := <-p.pairing:
+			if p.isTransferTarget(c) {
+				p.completeTransfer(c)
+				continue
+			}
+
+			// the first device of the callee answering the call takes the pair, the others are rejected
 			err := pairClient(p, c)
 			if err != nil {
-				c.messageHandleErrors <- messageHandleError{
+				c.logger().WithError(err).WithField("pair_id", p.id).Warn("couldn't pair a client")
+				c.failPairing(messageHandleError{
 					Code: errorCodePairing,
-					Desc: "Couldn't pair a client",
-				}
+					Desc: "The call has already been answered",
+				})
+				continue
 			}
 
 			c.setPair <- p
+			if p.clients[1] == c {
+				p.stopRinging(c)
+			}
+		case c := <-p.join:
+			p.addMonitor(c)
+		case c := <-p.barge:
+			p.bargeIn(c)
+		case c := <-p.leave:
+			p.removeParticipant(c)
+		case request := <-p.hold:
+			p.setHold(request)
 		case msg := <-p.broadcast:
-			for _, c := range p.clients {
-				if c != msg.client {
-					c.incoming <- msg.data
-				}
+			switch p.role(msg.client) {
+			case "":
+				// the previous callee of a transferred pair could still be signaling
+				msg.client.logger().WithField("pair_id", p.id).Debug("the signaling of a client which has left the pair is dropped")
+				continue
+			case roleMonitor:
+				msg.client.reportError(messageHandleError{
+					Code: errorCodeMonitoring,
+					Desc: "The signaling of a monitor isn't relayed until it barges in",
+				})
+				continue
 			}
+
+			if !p.filter(msg) {
+				continue
+			}
+
+			if p.relayed {
+				p.relay(msg)
+				continue
+			}
+
+			ctx := trace.ContextWithRemoteSpanContext(context.Background(), p.spanContext)
+			_, span := tracer.Start(ctx, "pair.firstRelay")
+			span.SetAttributes(attribute.String("pair_id", p.id.String()))
+			p.relay(msg)
+			span.End()
+			p.relayed = true
 		case <-p.terminate:
-			for _, c := range p.clients {
-				c.disconnect <- struct{}{}
+			if p.isPending() {
+				metrics.PendingPairs.Dec()
+			}
+
+			if p.removedCandidates > 0 {
+				log.WithFields(log.Fields{
+					"pair_id":            p.id,
+					"removed_candidates": p.removedCandidates,
+				}).Info("the private candidates have been removed from the signaling of the pair")
+			}
+
+			p.endHold()
+			if p.heldDuration > 0 {
+				log.WithFields(log.Fields{
+					"pair_id":       p.id,
+					"held_duration": p.heldDuration.String(),
+				}).Info("the pair has been held")
+			}
+
+			participants := make([]*client, 0, len(p.clients)+len(p.participants))
+			participants = append(participants, p.clients[:]...)
+			for c := range p.participants {
+				participants = append(participants, c)
+			}
+
+			for _, c := range participants {
+				// the released clients stay connected and leave the pair on their next message
+				if c == nil || p.hasReleased(c) {
+					continue
+				}
+
+				// the client could have already left, its terminate channel is closed in that case
+				select {
+				case c.disconnect <- p.reason:
+				case <-c.terminate:
+				}
 			}
 			return
 		}
 	}
 }
 
+// relay sends the message to the clients of the pair except its sender and to the participants
+func (p *pair) relay(msg *broadcast) {
+	from := p.role(msg.client)
+
+	if from != roleSupervisor {
+		for _, c := range p.clients {
+			if c != nil && c != msg.client {
+				c.incoming <- msg.data
+			}
+		}
+	}
+
+	p.relayToParticipants(msg, from)
+}
+
+// filter applies the sdp policy and the privacy mode to the signaling message,
+// it reports whether the message should be relayed
+func (p *pair) filter(msg *broadcast) bool {
+	if !p.relayOnly && p.sdpPolicy == nil {
+		return true
+	}
+
+	logger := msg.client.logger()
+
+	signaling, err := parseSignalingMessage(msg.data)
+	if err != nil {
+		logger.WithError(err).Warn("untyped signaling rejected")
+		msg.client.reportError(messageHandleError{
+			Code: errorCodeSignaling,
+			Desc: "Invalid signaling message",
+		})
+		return false
+	}
+
+	if err := p.validateSdp(signaling); err != nil {
+		logger.WithError(err).WithField("signaling_type", signaling.Type).Warn("the session description is rejected")
+		msg.client.reportError(messageHandleError{
+			Code: errorCodeSignaling,
+			Desc: "Invalid session description",
+		})
+		return false
+	}
+
+	if !p.relayOnly {
+		return p.encodeSignaling(msg, signaling)
+	}
+
+	removed, relay := applyPrivacy(signaling)
+	if removed > 0 {
+		p.removedCandidates += removed
+		metrics.PrivacyRemovedCandidates.Add(float64(removed))
+		logger.WithFields(log.Fields{
+			"signaling_type":     signaling.Type,
+			"removed_candidates": removed,
+		}).Debug("private candidates removed from the signaling")
+	}
+
+	if !relay {
+		return false
+	}
+
+	return p.encodeSignaling(msg, signaling)
+}
+
+// validateSdp parses the session description of an offer or an answer and rewrites it according to the sdp policy
+func (p *pair) validateSdp(signaling *signalingMessage) error {
+	if p.sdpPolicy == nil || signaling.Type == signalingTypeCandidate {
+		return nil
+	}
+
+	desc, err := sdp.Parse(signaling.Sdp)
+	if err != nil {
+		return err
+	}
+
+	if err := p.sdpPolicy.Apply(desc); err != nil {
+		return err
+	}
+	signaling.Sdp = desc.Marshal()
+
+	return nil
+}
+
+// encodeSignaling replaces the data of the broadcast with the encoded signaling message
+func (p *pair) encodeSignaling(msg *broadcast, signaling *signalingMessage) bool {
+	data, err := signaling.Encode()
+	if err != nil {
+		msg.client.logger().WithError(err).Error("couldn't encode the signaling message")
+		return false
+	}
+	msg.data = data
+
+	return true
+}
+
+// isAnswered reports whether the second client has joined the pair
+func (p *pair) isAnswered() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.clients[1] != nil
+}
+
+// hasReleased reports whether the client has been released from the pair without leaving it,
+// like the previous callee of a transferred pair or the helper of a removed pair
+func (p *pair) hasReleased(c *client) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.released[c] || p.role(c) == ""
+}
+
+// release keeps the client connected once the pair is terminated
+func (p *pair) release(c *client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.released == nil {
+		p.released = make(map[*client]bool)
+	}
+	p.released[c] = true
+}
+
+// isPending reports whether the pair is waiting for the second client
+func (p *pair) isPending() bool {
+	return p.clients[0] != nil && p.clients[1] == nil
+}
+
 func pairClient(p *pair, c *client) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.clients[0] == nil {
 		p.clients[0] = c
+		metrics.PendingPairs.Inc()
 		return nil
 	}
 
@@ -72,5 +343,6 @@ func pairClient(p *pair, c *client) error {
 	}
 
 	p.clients[1] = c
+	metrics.PendingPairs.Dec()
 	return nil
 }
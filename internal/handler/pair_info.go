@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ErrPairNotFound is returned if there is no pair with a given ID in the hub
+var ErrPairNotFound = errors.New("the pair with a given ID wasn't found in the hub")
+
+// PairInfo describes a pair registered in the hub
+type PairInfo struct {
+	ID               uuid.UUID         `json:"id"`
+	Media            string            `json:"media"`
+	Priority         string            `json:"priority"`
+	ParticipantCount int               `json:"participant_count"`
+	CreatedAt        time.Time         `json:"created_at"`
+	Age              float64           `json:"age_seconds"`
+	OnHold           bool              `json:"on_hold"`
+	HeldFor          float64           `json:"held_seconds"`
+	Participants     []ParticipantInfo `json:"participants"`
+}
+
+// ParticipantInfo describes a client connected to a pair
+type ParticipantInfo struct {
+	// Role is either caller, callee, monitor or supervisor
+	Role        string    `json:"role"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+type pairQuery struct {
+	pairID uuid.UUID
+	result chan *PairInfo
+}
+
+type pairTermination struct {
+	pairID uuid.UUID
+	reason messageHandleError
+	result chan error
+}
+
+func (p *pair) info() *PairInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	info := &PairInfo{
+		ID:           p.id,
+		Media:        p.call.Media,
+		Priority:     p.call.Priority,
+		CreatedAt:    p.createdAt,
+		Age:          time.Since(p.createdAt).Seconds(),
+		OnHold:       p.heldBy != nil,
+		HeldFor:      p.heldFor().Seconds(),
+		Participants: make([]ParticipantInfo, 0, len(p.clients)+len(p.participants)),
+	}
+
+	participants := append([]*client{}, p.clients[:]...)
+	for c := range p.participants {
+		participants = append(participants, c)
+	}
+
+	for _, c := range participants {
+		if c == nil {
+			continue
+		}
+
+		info.Participants = append(info.Participants, ParticipantInfo{
+			Role:        p.role(c),
+			RemoteAddr:  c.conn.RemoteAddr().String(),
+			ConnectedAt: c.connectedAt,
+		})
+	}
+	info.ParticipantCount = len(info.Participants)
+
+	return info
+}
+
+// Pairs returns the pairs registered in the hub
+func (s *Server) Pairs(ctx context.Context) ([]*PairInfo, error) {
+	result := make(chan []*PairInfo, 1)
+
+	select {
+	case s.hub.list <- result:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "the hub didn't respond")
+	}
+
+	return <-result, nil
+}
+
+// Pair returns the pair with a given ID or ErrPairNotFound
+func (s *Server) Pair(ctx context.Context, pairID uuid.UUID) (*PairInfo, error) {
+	query := &pairQuery{pairID: pairID, result: make(chan *PairInfo, 1)}
+
+	select {
+	case s.hub.find <- query:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "the hub didn't respond")
+	}
+
+	info := <-query.result
+	if info == nil {
+		return nil, ErrPairNotFound
+	}
+
+	return info, nil
+}
+
+// TerminatePair disconnects both clients of the pair with a given ID notifying them
+// that the call has been terminated by an administrator
+func (s *Server) TerminatePair(ctx context.Context, pairID uuid.UUID) error {
+	termination := &pairTermination{
+		pairID: pairID,
+		reason: messageHandleError{
+			Code: errorCodeAdminTerminated,
+			Desc: "The call has been terminated by an administrator",
+		},
+		result: make(chan error, 1),
+	}
+
+	select {
+	case s.hub.terminatePair <- termination:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "the hub didn't respond")
+	}
+
+	return <-termination.result
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"unicode/utf8"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The channels the callees are notified about the calls through
+const (
+	notificationChannelWebSocket = "websocket"
+	notificationChannelApi       = "api"
+)
+
+const maxUserIDLength = 256
+
+// presence registers the client as the online device of a user or removes it from the registry
+type presence struct {
+	client *client
+	userID string
+}
+
+type presenceQuery struct {
+	userID string
+	result chan []*client
+}
+
+// incomingCallPayload is the content of the incoming call message ringing an online callee
+type incomingCallPayload struct {
+	PairID uuid.UUID     `json:"pair_id"`
+	Call   *callMetadata `json:"call"`
+}
+
+// answeredElsewherePayload is the content of the message stopping the ringing of the devices which haven't answered
+type answeredElsewherePayload struct {
+	PairID uuid.UUID `json:"pair_id"`
+}
+
+// parseUserID returns the user ID the client registers with, only the authenticated clients could register
+// and only as their subject, so no one could be rung for the calls of another user
+func parseUserID(content []byte, subject string) (string, error) {
+	if subject == "" {
+		return "", errors.New("the user ID can't be registered without an authenticated subject")
+	}
+
+	userID := string(content)
+	if userID == "" {
+		userID = subject
+	}
+
+	if userID == "" {
+		return "", errors.New("the user ID is empty")
+	}
+
+	if !utf8.ValidString(userID) || len(userID) > maxUserIDLength {
+		return "", errors.Errorf("the user ID must be a valid UTF-8 string of at most %d bytes", maxUserIDLength)
+	}
+
+	if userID != subject {
+		return "", errors.Errorf("the user ID '%s' doesn't match the subject of the connection", userID)
+	}
+
+	return userID, nil
+}
+
+// registerPresence makes the client reachable by the calls addressed to a given user
+func (c *client) registerPresence(userID string) {
+	if c.userID != "" && c.userID != userID {
+		c.hub.offline <- &presence{client: c, userID: c.userID}
+	}
+
+	c.hub.online <- &presence{client: c, userID: userID}
+	c.userID = userID
+	c.entry.Store(c.logger().WithField("user_id", userID))
+}
+
+// ringOnline rings all the online devices of the callee of the pair, it reports whether any device has been rung
+func (c *client) ringOnline(ctx context.Context, p *pair) bool {
+	if p.callee == "" {
+		return false
+	}
+
+	_, span := tracer.Start(ctx, "client.ringOnline")
+	defer span.End()
+	span.SetAttributes(attribute.String("pair_id", p.id.String()))
+
+	content, err := json.Marshal(&incomingCallPayload{PairID: p.id, Call: &p.call})
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the incoming call payload")
+		return false
+	}
+	msg := connectionMessage{Typ: outgoingMessageIncomingCall, Content: content}
+
+	query := &presenceQuery{userID: p.callee, result: make(chan []*client, 1)}
+	c.hub.findUser <- query
+	devices := <-query.result
+
+	// the ringing devices are recorded under the lock of the pair before they're rung, so a device answering meanwhile
+	// gets all of them to send the answered elsewhere message. The devices are rung outside of the lock,
+	// the pair isn't held up by a slow device
+	p.mu.Lock()
+	ringing := make([]*client, 0, len(devices))
+	for _, device := range devices {
+		if device != c {
+			ringing = append(ringing, device)
+		}
+	}
+	p.ringing = append(p.ringing, ringing...)
+	p.mu.Unlock()
+
+	rung := 0
+	for _, device := range ringing {
+		// the device could have left after the registry has been queried
+		if !p.isAnswered() && device.notify(msg) {
+			rung++
+		}
+	}
+	span.SetAttributes(attribute.Int("rung_devices", rung))
+
+	// the call answered while the devices are rung doesn't need the API
+	return rung > 0 || p.isAnswered()
+}
+
+// notifyCallee notifies the callee about the call of the pair over their connection, or through the API if they're offline
+func (c *client) notifyCallee(ctx context.Context, p *pair) error {
+	if c.ringOnline(ctx, p) {
+		metrics.CallNotifications.WithLabelValues(notificationChannelWebSocket).Inc()
+		c.logger().WithField("callee", p.callee).Debug("the callee has been rung over their connection")
+		return nil
+	}
+
+	metrics.CallNotifications.WithLabelValues(notificationChannelApi).Inc()
+	return callApi(ctx, c.api, p)
+}
+
+// stopRinging posts the answered elsewhere message to the ringing devices except the one which has answered the call,
+// the pair doesn't wait for the writers of the devices
+func (p *pair) stopRinging(answered *client) {
+	p.mu.RLock()
+	devices := p.ringing
+	p.mu.RUnlock()
+
+	content, err := json.Marshal(&answeredElsewherePayload{PairID: p.id})
+	if err != nil {
+		log.WithError(err).WithField("pair_id", p.id).Error("couldn't encode the answered elsewhere payload")
+		return
+	}
+	msg := connectionMessage{Typ: outgoingMessageAnsweredElsewhere, Content: content}
+
+	for _, device := range devices {
+		if device != answered {
+			device.post(msg)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseUserID(t *testing.T) {
+	if userID, err := parseUserID(nil, "alice"); err != nil || userID != "alice" {
+		t.Errorf("the subject expected to be used without the content, got '%s', %v", userID, err)
+	}
+
+	if _, err := parseUserID([]byte("bob"), "alice"); err == nil {
+		t.Error("an error expected for a user ID not matching the subject")
+	}
+
+	if _, err := parseUserID([]byte("bob"), ""); err == nil {
+		t.Error("an error expected for a user ID registered by an anonymous client")
+	}
+}
+
+func TestHub_ring_online_callee(t *testing.T) {
+	h := newTestHub(t, nil, Options{})
+	callerConn := h.connect("")
+	calleeConn := h.connect("bob")
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessagePresence, Content: []byte("bob")})
+	if _, err := testExpectMessage(calleeConn, outgoingMessagePresenceRegistered); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(callerConn, connectionMessage{
+		Typ:     incomingMessageCall,
+		Content: []byte(`{"callee":"bob","media":"audio","display_name":"Alice"}`),
+	})
+
+	msg, err := testExpectMessage(calleeConn, outgoingMessageIncomingCall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var incoming incomingCallPayload
+	if err := json.Unmarshal(msg.Content, &incoming); err != nil {
+		t.Fatalf("couldn't decode the incoming call: %s", err)
+	}
+	if incoming.Call.Media != mediaTypeAudio || incoming.Call.DisplayName != "Alice" {
+		t.Errorf("unexpected call metadata: %+v", incoming.Call)
+	}
+
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessageAnswer, Content: incoming.PairID[:]})
+	if _, err := testExpectMessage(calleeConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHub_first_answer_wins(t *testing.T) {
+	h := newTestHub(t, nil, Options{})
+	callerConn := h.connect("")
+	phoneConn := h.connect("bob")
+	tabletConn := h.connect("bob")
+
+	for _, conn := range []*webSocketConnStub{phoneConn, tabletConn} {
+		testSendMessage(conn, connectionMessage{Typ: incomingMessagePresence, Content: []byte("bob")})
+		if _, err := testExpectMessage(conn, outgoingMessagePresenceRegistered); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"callee":"bob"}`)})
+
+	var incoming incomingCallPayload
+	for _, conn := range []*webSocketConnStub{phoneConn, tabletConn} {
+		msg, err := testExpectMessage(conn, outgoingMessageIncomingCall)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(msg.Content, &incoming); err != nil {
+			t.Fatalf("couldn't decode the incoming call: %s", err)
+		}
+	}
+
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(phoneConn, connectionMessage{Typ: incomingMessageAnswer, Content: incoming.PairID[:]})
+	if _, err := testExpectMessage(phoneConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(tabletConn, outgoingMessageAnsweredElsewhere); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(tabletConn, connectionMessage{Typ: incomingMessageAnswer, Content: incoming.PairID[:]})
+	if _, err := testExpectMessage(tabletConn, outgoingMessageError); err != nil {
+		t.Fatal(err)
+	}
+}
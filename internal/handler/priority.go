@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// The reason the call offered to the helpers at once is withdrawn from the helpers who haven't answered it
+const offerAnswered = "answered"
+
+// insertCall inserts the call ahead of the calls having a lower priority,
+// either ahead of or behind the calls having the same priority
+func (h *hub) insertCall(call *queuedCall, ahead bool) {
+	i := 0
+	for ; i < len(h.queue); i++ {
+		queued := h.queue[i]
+		if queued.priority < call.priority || (ahead && queued.priority == call.priority) {
+			break
+		}
+	}
+
+	h.queue = append(h.queue, nil)
+	copy(h.queue[i+1:], h.queue[i:])
+	h.queue[i] = call
+}
+
+// requeue puts the call withdrawn from a helper back to the head of the calls having its priority
+// once it isn't offered to any helper
+func (h *hub) requeue(call *queuedCall) {
+	call.pending--
+	if call.pending > 0 {
+		return
+	}
+
+	h.insertCall(call, true)
+}
+
+// ringAll offers the call to all the available helpers who haven't declined it regardless of their skills,
+// it reports whether the call has been offered to any helper
+func (h *hub) ringAll(call *queuedCall) bool {
+	helpers := make([]*client, 0, len(h.helpers))
+	for c, state := range h.helpers {
+		if state.state == helperStateAvailable && !call.declinedBy[c] {
+			helpers = append(helpers, c)
+		}
+	}
+
+	if len(helpers) == 0 {
+		return false
+	}
+
+	metrics.QueueWaitDuration.Observe(time.Since(call.enqueuedAt).Seconds())
+	call.caller.logger().WithFields(log.Fields{
+		"pair_id": call.pair.id,
+		"helpers": len(helpers),
+	}).Warn("the emergency call is offered to all the available helpers")
+
+	offered := false
+	for _, helper := range helpers {
+		offered = h.offer(call, helper) || offered
+	}
+
+	if offered {
+		h.notifyHelperAssigned(call)
+	}
+
+	return offered
+}
+
+// withdrawAnsweredOffers withdraws the call the helper has answered from the other helpers it's offered to,
+// the helpers are available again
+func (h *hub) withdrawAnsweredOffers(helper *client) {
+	answering, ok := h.helpers[helper]
+	if !ok || answering.assigned == nil || answering.assigned.pending <= 1 {
+		return
+	}
+	call := answering.assigned
+
+	content, err := json.Marshal(&offerWithdrawnPayload{PairID: call.pair.id, Reason: offerAnswered})
+	if err != nil {
+		helper.logger().WithError(err).Error("couldn't encode the offer withdrawn payload")
+		return
+	}
+	msg := connectionMessage{Typ: outgoingMessageOfferWithdrawn, Content: content}
+
+	for c, state := range h.helpers {
+		if c == helper || state.assigned != call {
+			continue
+		}
+
+		state.assigned = nil
+		call.pending--
+		metrics.OffersWithdrawn.WithLabelValues(offerAnswered).Inc()
+		c.post(msg)
+		h.setHelperState(c, state, helperStateAvailable)
+	}
+
+	h.distribute()
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHub_insertCall(t *testing.T) {
+	h := newHub(nil, nil, Options{})
+	first := &queuedCall{}
+	second := &queuedCall{}
+	urgent := &queuedCall{priority: priorityRanks[priorityUrgent]}
+	emergency := &queuedCall{priority: priorityRanks[priorityEmergency]}
+	requeued := &queuedCall{}
+
+	h.insertCall(first, false)
+	h.insertCall(second, false)
+	h.insertCall(urgent, false)
+	h.insertCall(emergency, false)
+	h.insertCall(requeued, true)
+
+	expected := []*queuedCall{emergency, urgent, requeued, first, second}
+	for i, call := range expected {
+		if h.queue[i] != call {
+			t.Errorf("unexpected call at the position %d", i+1)
+		}
+	}
+}
+
+func TestHub_ring_all_for_emergency_call(t *testing.T) {
+	h := newTestHub(t, nil, Options{Queue: QueueOptions{RingAll: true}})
+	callerConn := h.connect("")
+	firstConn := h.connect("first")
+	secondConn := h.connect("second")
+
+	testSendMessage(firstConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available","skills":["de"]}`)})
+	testSendMessage(secondConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available","skills":["en"]}`)})
+
+	testSendMessage(callerConn, connectionMessage{
+		Typ:     incomingMessageCall,
+		Content: []byte(`{"helper":true,"priority":"emergency","requirements":["de"]}`),
+	})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := testExpectMessage(firstConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+	if assigned.Call.Priority != priorityEmergency {
+		t.Errorf("the emergency priority expected in the assignment, got '%s'", assigned.Call.Priority)
+	}
+
+	// the helper without the required skill is rung too
+	if _, err := testExpectMessage(secondConn, outgoingMessageCallAssigned); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(firstConn, connectionMessage{Typ: incomingMessageAnswer, Content: assigned.PairID[:]})
+	if _, err := testExpectMessage(firstConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err = testExpectMessage(secondConn, outgoingMessageOfferWithdrawn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var withdrawn offerWithdrawnPayload
+	if err := json.Unmarshal(msg.Content, &withdrawn); err != nil {
+		t.Fatalf("couldn't decode the withdrawn offer: %s", err)
+	}
+	if withdrawn.Reason != offerAnswered {
+		t.Errorf("the offer expected to be withdrawn as answered, got '%s'", withdrawn.Reason)
+	}
+}
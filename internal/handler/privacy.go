@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"strings"
+)
+
+// The candidate types revealing the addresses of a client, only the relay candidates are kept in the privacy mode
+var privateCandidateTypes = map[string]bool{
+	"host":  true,
+	"srflx": true,
+	"prflx": true,
+}
+
+// The related address and port replacing the ones of the kept relay candidates, like the browsers hiding them
+const (
+	hiddenRelatedAddress = "0.0.0.0"
+	hiddenRelatedPort    = "0"
+)
+
+// unspecifiedAddresses replace the addresses of the connection lines, ICE relies on the candidates anyway
+var unspecifiedAddresses = map[string]string{
+	"IP4": "0.0.0.0",
+	"IP6": "::",
+}
+
+// applyPrivacy removes the candidates revealing the addresses of the sender from the signaling message
+// and hides the related addresses of the kept ones. It returns the number of removed candidates and whether
+// anything is left to relay
+func applyPrivacy(msg *signalingMessage) (removed int, relay bool) {
+	switch msg.Type {
+	case signalingTypeCandidate:
+		// an empty candidate signals the end of candidates
+		if msg.Candidate.Candidate == "" {
+			break
+		}
+
+		if isPrivateCandidate(msg.Candidate.Candidate) {
+			return 1, false
+		}
+		msg.Candidate.Candidate = hideRelatedAddress(msg.Candidate.Candidate)
+	case signalingTypeOffer, signalingTypeAnswer:
+		msg.Sdp, removed = stripPrivateCandidates(msg.Sdp)
+	}
+
+	return removed, true
+}
+
+// stripPrivateCandidates removes the private candidates from the sdp and hides the addresses of its connection lines
+// and the related addresses of the kept candidates
+func stripPrivateCandidates(sdp string) (string, int) {
+	lineBreak := "\r\n"
+	if !strings.Contains(sdp, lineBreak) {
+		lineBreak = "\n"
+	}
+
+	lines := strings.Split(sdp, lineBreak)
+	kept := make([]string, 0, len(lines))
+	removed := 0
+
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidate := strings.TrimPrefix(line, "a=")
+			if isPrivateCandidate(candidate) {
+				removed++
+				continue
+			}
+			line = "a=" + hideRelatedAddress(candidate)
+		case strings.HasPrefix(line, "c="):
+			line = "c=" + hideAddress(strings.TrimPrefix(line, "c="), 0)
+		case strings.HasPrefix(line, "a=rtcp:"):
+			line = hideAddress(line, 1)
+		}
+
+		kept = append(kept, line)
+	}
+
+	return strings.Join(kept, lineBreak), removed
+}
+
+// isPrivateCandidate reports whether the candidate attribute (candidate:foundation component transport
+// priority address port typ type ...) is of a type revealing the addresses of a client
+func isPrivateCandidate(candidate string) bool {
+	fields := strings.Fields(candidate)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "typ" {
+			return privateCandidateTypes[fields[i+1]]
+		}
+	}
+
+	// a malformed candidate is not relayed in the privacy mode
+	return true
+}
+
+// hideAddress replaces the address of a value with the "IN <addrtype> <address>" fields starting at a given index
+func hideAddress(value string, netTypeIndex int) string {
+	fields := strings.Fields(value)
+	if len(fields) < netTypeIndex+3 || fields[netTypeIndex] != "IN" {
+		return value
+	}
+
+	unspecified, ok := unspecifiedAddresses[fields[netTypeIndex+1]]
+	if !ok {
+		return value
+	}
+
+	// the address could be followed by the TTL or the number of addresses
+	address := strings.SplitN(fields[netTypeIndex+2], "/", 2)
+	address[0] = unspecified
+	fields[netTypeIndex+2] = strings.Join(address, "/")
+
+	return strings.Join(fields, " ")
+}
+
+// hideRelatedAddress replaces the values of the raddr and rport fields of the candidate attribute,
+// the related address of a relay candidate is the server reflexive address of the client
+func hideRelatedAddress(candidate string) string {
+	fields := strings.Fields(candidate)
+	hidden := false
+
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "raddr":
+			fields[i+1] = hiddenRelatedAddress
+			hidden = true
+		case "rport":
+			fields[i+1] = hiddenRelatedPort
+			hidden = true
+		}
+	}
+
+	if !hidden {
+		return candidate
+	}
+
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSdp = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 54400 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"c=IN IP4 203.0.113.7\r\n" +
+	"a=rtcp:54401 IN IP4 203.0.113.7\r\n" +
+	"a=candidate:1 1 udp 2122260223 192.168.1.10 54400 typ host generation 0\r\n" +
+	"a=candidate:2 1 udp 1686052607 203.0.113.7 54400 typ srflx raddr 192.168.1.10 rport 54400\r\n" +
+	"a=candidate:3 1 udp 41885439 198.51.100.1 3478 typ relay raddr 203.0.113.7 rport 54400\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n"
+
+func TestApplyPrivacy_sdp(t *testing.T) {
+	msg := &signalingMessage{Type: signalingTypeOffer, Sdp: testSdp}
+
+	removed, relay := applyPrivacy(msg)
+
+	if !relay || removed != 2 {
+		t.Fatalf("two candidates expected to be removed from a relayed offer, got %d (relay: %v)", removed, relay)
+	}
+
+	if strings.Contains(msg.Sdp, "typ host") || strings.Contains(msg.Sdp, "typ srflx") {
+		t.Errorf("private candidates left in the sdp:\n%s", msg.Sdp)
+	}
+
+	if !strings.Contains(msg.Sdp, "typ relay raddr 0.0.0.0 rport 0\r\n") {
+		t.Errorf("the relay candidate expected to be kept hiding its related address:\n%s", msg.Sdp)
+	}
+
+	if strings.Contains(msg.Sdp, "c=IN IP4 203.0.113.7") || strings.Contains(msg.Sdp, "a=rtcp:54401 IN IP4 203.0.113.7") {
+		t.Errorf("the connection addresses expected to be hidden:\n%s", msg.Sdp)
+	}
+}
+
+func TestApplyPrivacy_candidate(t *testing.T) {
+	host, err := parseSignalingMessage([]byte(`{"type":"candidate","candidate":{"candidate":"candidate:1 1 udp 2122260223 192.168.1.10 54400 typ host","sdpMid":"0"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if removed, relay := applyPrivacy(host); relay || removed != 1 {
+		t.Errorf("a host candidate expected to be removed, got %d (relay: %v)", removed, relay)
+	}
+
+	relayCandidate, err := parseSignalingMessage([]byte(`{"type":"candidate","candidate":{"candidate":"candidate:3 1 udp 41885439 198.51.100.1 3478 typ relay raddr 203.0.113.7 rport 54400","sdpMid":"0"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if removed, relay := applyPrivacy(relayCandidate); !relay || removed != 0 {
+		t.Errorf("a relay candidate expected to be relayed, got %d (relay: %v)", removed, relay)
+	}
+
+	data, err := relayCandidate.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if relayCandidate.Candidate.Candidate != "candidate:3 1 udp 41885439 198.51.100.1 3478 typ relay raddr 0.0.0.0 rport 0" {
+		t.Errorf("the related address of the relay candidate expected to be hidden, got '%s'", relayCandidate.Candidate.Candidate)
+	}
+
+	if !strings.Contains(string(data), `"sdpMid":"0"`) {
+		t.Errorf("the unknown candidate fields expected to be kept, got %s", data)
+	}
+}
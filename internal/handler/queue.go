@@ -0,0 +1,477 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The number of the latest handle times the wait of the queued calls is estimated with
+const maxHandleTimes = 20
+
+// QueueOptions configures the updates sent to the callers waiting for a helper
+type QueueOptions struct {
+	// StatusInterval is the period of the queue status updates, the updates are disabled if it's zero
+	StatusInterval time.Duration
+	// MaxWait is the wait after which the caller gets the fallback message, the message isn't sent if either is empty
+	MaxWait time.Duration
+	// Fallback is the message sent to the callers waiting longer than MaxWait, e.g. the number of a hotline
+	Fallback string
+	// RelaxAfter is the wait after which a call is assigned to the helper having the most of the required skills
+	// if no helper has all of them, the requirements are never relaxed if it's zero
+	RelaxAfter time.Duration
+	// OfferTimeout is the time a helper has to answer the offered call before it's offered to the next helper,
+	// the offers don't expire if it's zero
+	OfferTimeout time.Duration
+	// MaxOffers is the number of the declined or expired offers after which the call is escalated to the supervisors,
+	// the calls aren't escalated if it's zero. The escalated calls are routed like the others while no supervisor is online
+	MaxOffers int
+	// WrapUp is the time the helpers spend in the wrap-up state after a call before they're available again,
+	// the helpers are available right away if it's zero
+	WrapUp time.Duration
+	// RingAll offers the emergency calls to all the available helpers at once, the first helper answering takes the call
+	RingAll bool
+}
+
+// The statuses a helper reports to the hub, unavailable is the on break status of the helpers
+// reporting it before the helper states were introduced
+const (
+	helperStatusAvailable   = "available"
+	helperStatusOnBreak     = "on_break"
+	helperStatusUnavailable = "unavailable"
+)
+
+// queuedCall is a call waiting in the queue for an available helper
+type queuedCall struct {
+	pair       *pair
+	caller     *client
+	enqueuedAt time.Time
+	// fallbackSent is set once the caller has got the fallback message, the caller stays in the queue
+	fallbackSent bool
+	// requirements are the skills a helper needs to take the call
+	requirements []string
+	relaxed      bool
+	// offers is the number of the helpers who have declined the call or haven't answered it in time,
+	// the call isn't offered to them again
+	offers     int
+	declinedBy map[*client]bool
+	escalated  bool
+	// transfer is set for the answered calls transferred to another helper, the caller is the transferring helper
+	transfer bool
+	// priority is the rank of the priority of the call, the calls are queued ahead of the ones having a lower rank
+	priority int
+	// pending is the number of the helpers the call is offered to at the moment
+	pending int
+}
+
+// helperState is the state of a client registered as a helper in the hub
+type helperState struct {
+	state string
+	// since is the time the helper has entered the state, it's used to assign the calls to the longest idle helper
+	since time.Time
+	// wrapUpEnds is the time the helper in the wrap-up state becomes available
+	wrapUpEnds time.Time
+	// assigned is the call assigned to the helper, the helper is available again when its pair is removed
+	assigned   *queuedCall
+	assignedAt time.Time
+	// monitoring is the pair the supervisor has joined, the supervisor is available again when it's removed
+	monitoring *pair
+	skills     map[string]bool
+}
+
+// helperStatus changes the state of a helper in the hub to either available or on break, left removes the helper.
+// The skills of the helper are kept if they're nil
+type helperStatus struct {
+	client *client
+	state  string
+	skills []string
+	left   bool
+}
+
+type helperStatusRequest struct {
+	Status string   `json:"status"`
+	Skills []string `json:"skills"`
+}
+
+// callAssignedPayload is the content of the call assigned message sent to the helper, the helper answers the pair
+type callAssignedPayload struct {
+	PairID uuid.UUID     `json:"pair_id"`
+	Call   *callMetadata `json:"call"`
+}
+
+// helperAssignedPayload is the content of the helper assigned message sent to the caller
+type helperAssignedPayload struct {
+	PairID uuid.UUID `json:"pair_id"`
+}
+
+// queueStatusPayload is the content of the queue status message sent to the waiting callers,
+// the wait isn't estimated until the helpers have handled a call
+type queueStatusPayload struct {
+	PairID        uuid.UUID `json:"pair_id"`
+	Position      int       `json:"position"`
+	EstimatedWait *float64  `json:"estimated_wait_seconds,omitempty"`
+}
+
+// queueFallbackPayload is the content of the message sent to the callers waiting longer than the maximum wait
+type queueFallbackPayload struct {
+	PairID  uuid.UUID `json:"pair_id"`
+	Message string    `json:"message"`
+}
+
+// queueNotification is a message sent by the hub to a queued caller
+type queueNotification struct {
+	client *client
+	msg    connectionMessage
+}
+
+func parseHelperStatus(content []byte) (*helperStatus, error) {
+	var request helperStatusRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, errors.Wrap(err, "invalid helper status request")
+	}
+
+	status := &helperStatus{}
+	switch request.Status {
+	case helperStatusAvailable:
+		status.state = helperStateAvailable
+	case helperStatusOnBreak, helperStatusUnavailable:
+		status.state = helperStateOnBreak
+	default:
+		return nil, errors.Errorf("unknown helper status: '%s'", request.Status)
+	}
+
+	if request.Skills != nil {
+		skills, err := parseSkills(request.Skills)
+		if err != nil {
+			return nil, err
+		}
+		status.skills = skills
+	}
+
+	return status, nil
+}
+
+// setHelperStatus registers the client as a helper with a given status and distributes the waiting calls,
+// the busy helpers keep their state and the wrap-up could be ended early
+func (h *hub) setHelperStatus(status *helperStatus) {
+	c := status.client
+	state, ok := h.helpers[c]
+
+	if status.left {
+		if ok && state.assigned != nil && h.isWaitingForHelper(c, state.assigned) {
+			// the helper has left without answering, the call gets back to the head of the queue
+			c.logger().WithField("pair_id", state.assigned.pair.id).Info("the call is requeued, the helper has left")
+			h.requeue(state.assigned)
+		}
+		delete(h.helpers, c)
+		h.distribute()
+		h.updateHelperMetrics()
+		return
+	}
+
+	if !ok {
+		state = &helperState{skills: map[string]bool{}}
+		h.helpers[c] = state
+	}
+
+	if status.skills != nil {
+		state.skills = make(map[string]bool, len(status.skills))
+		for _, skill := range status.skills {
+			state.skills[skill] = true
+		}
+	}
+
+	switch {
+	case state.state == helperStateBusy:
+		c.logger().WithField("status", status.state).Debug("the status of the busy helper is kept")
+		h.notifyHelperState(c, state)
+	case state.state == status.state:
+		// the helper gets its state back anyway
+		h.notifyHelperState(c, state)
+	default:
+		h.setHelperState(c, state, status.state)
+	}
+
+	h.distribute()
+}
+
+// enqueueCall puts the call behind the calls having the same or a higher priority and distributes the waiting calls
+func (h *hub) enqueueCall(call *queuedCall) {
+	call.priority = priorityRanks[call.pair.call.Priority]
+	h.insertCall(call, false)
+	call.caller.logger().WithFields(log.Fields{
+		"queue_length": len(h.queue),
+		"priority":     call.pair.call.Priority,
+	}).Debug("the call is waiting for a helper")
+	h.distribute()
+
+	// the caller gets its position right away if no helper is available
+	for i, queued := range h.queue {
+		if queued == call {
+			h.notifyQueued(h.appendQueueStatus(nil, call, i+1))
+			break
+		}
+	}
+}
+
+// sendQueueStatus sends the position and the estimated wait to the queued callers
+func (h *hub) sendQueueStatus() {
+	h.pruneQueue()
+
+	notifications := make([]queueNotification, 0, len(h.queue))
+	for i, call := range h.queue {
+		notifications = h.appendQueueStatus(notifications, call, i+1)
+	}
+
+	h.notifyQueued(notifications)
+}
+
+// isFallbackEnabled reports whether the callers waiting longer than the maximum wait get the fallback message
+func (h *hub) isFallbackEnabled() bool {
+	return h.queueOptions.MaxWait > 0 && h.queueOptions.Fallback != ""
+}
+
+// sendFallbacks sends the fallback message to the queued callers waiting longer than the maximum wait,
+// each caller gets it once
+func (h *hub) sendFallbacks() {
+	if !h.isFallbackEnabled() {
+		return
+	}
+
+	var notifications []queueNotification
+	for _, call := range h.queue {
+		if call.fallbackSent || time.Since(call.enqueuedAt) < h.queueOptions.MaxWait {
+			continue
+		}
+
+		content, err := json.Marshal(&queueFallbackPayload{PairID: call.pair.id, Message: h.queueOptions.Fallback})
+		if err != nil {
+			call.caller.logger().WithError(err).Error("couldn't encode the queue fallback payload")
+			continue
+		}
+
+		call.fallbackSent = true
+		call.caller.logger().WithField("pair_id", call.pair.id).Info("the call has waited longer than the maximum wait")
+		notifications = append(notifications, queueNotification{
+			client: call.caller,
+			msg:    connectionMessage{Typ: outgoingMessageQueueFallback, Content: content},
+		})
+	}
+
+	h.notifyQueued(notifications)
+}
+
+// appendQueueStatus appends the queue status message for the call at a given position starting from 1
+func (h *hub) appendQueueStatus(notifications []queueNotification, call *queuedCall, position int) []queueNotification {
+	status := &queueStatusPayload{PairID: call.pair.id, Position: position}
+	if wait, ok := h.estimatedWait(position); ok {
+		seconds := wait.Seconds()
+		status.EstimatedWait = &seconds
+	}
+
+	content, err := json.Marshal(status)
+	if err != nil {
+		call.caller.logger().WithError(err).Error("couldn't encode the queue status payload")
+		return notifications
+	}
+
+	return append(notifications, queueNotification{
+		client: call.caller,
+		msg:    connectionMessage{Typ: outgoingMessageQueueStatus, Content: content},
+	})
+}
+
+// estimatedWait estimates the wait of the call at a given position assuming all the helpers
+// take the calls from the queue one after another spending the average of the latest handle times on each
+func (h *hub) estimatedWait(position int) (time.Duration, bool) {
+	if len(h.handleTimes) == 0 || len(h.helpers) == 0 {
+		return 0, false
+	}
+
+	var total time.Duration
+	for _, handleTime := range h.handleTimes {
+		total += handleTime
+	}
+	average := total / time.Duration(len(h.handleTimes))
+
+	rounds := (position + len(h.helpers) - 1) / len(h.helpers)
+
+	return time.Duration(rounds) * average, true
+}
+
+// notifyQueued posts the notifications to the queued callers
+func (h *hub) notifyQueued(notifications []queueNotification) {
+	for _, n := range notifications {
+		n.client.post(n.msg)
+	}
+}
+
+// pruneQueue removes the calls the callers have left from the queue
+func (h *hub) pruneQueue() {
+	queue := h.queue[:0]
+	for _, call := range h.queue {
+		if _, ok := h.pairs[call.pair.id]; ok {
+			queue = append(queue, call)
+		}
+	}
+	h.queue = queue
+	h.updateQueueMetrics()
+}
+
+func (h *hub) recordHandleTime(handleTime time.Duration) {
+	h.handleTimes = append(h.handleTimes, handleTime)
+	if len(h.handleTimes) > maxHandleTimes {
+		h.handleTimes = h.handleTimes[len(h.handleTimes)-maxHandleTimes:]
+	}
+}
+
+// distribute assigns the calls in the order of the queue to the longest idle available helpers having the required skills,
+// a call no available helper matches doesn't hold the calls behind it
+func (h *hub) distribute() {
+	queue := make([]*queuedCall, 0, len(h.queue))
+
+	for _, call := range h.queue {
+		if _, ok := h.pairs[call.pair.id]; !ok {
+			// the caller has left the queue
+			continue
+		}
+
+		if !call.relaxed && h.isRelaxed(call) {
+			call.relaxed = true
+			call.caller.logger().WithFields(log.Fields{
+				"pair_id":      call.pair.id,
+				"requirements": call.requirements,
+			}).Info("the routing requirements of the call are relaxed")
+		}
+
+		if call.priority == priorityRanks[priorityEmergency] && h.queueOptions.RingAll && h.ringAll(call) {
+			continue
+		}
+
+		// the call stays in the queue if it couldn't be offered to the matched helper
+		helper := h.matchHelper(call)
+		if helper == nil || !h.assign(call, helper) {
+			queue = append(queue, call)
+		}
+	}
+
+	h.queue = queue
+	h.updateQueueMetrics()
+}
+
+// assign notifies the helper and the caller about the assignment, the helper joins the pair answering it.
+// It reports whether the call has been offered to the helper
+func (h *hub) assign(call *queuedCall, helper *client) bool {
+	if !h.offer(call, helper) {
+		return false
+	}
+
+	metrics.QueueWaitDuration.Observe(time.Since(call.enqueuedAt).Seconds())
+	h.notifyHelperAssigned(call)
+
+	return true
+}
+
+// offer assigns the call to the helper and sends them the call, it reports whether the call has been sent.
+// The states of the call and the helper are kept if the call couldn't be sent
+func (h *hub) offer(call *queuedCall, helper *client) bool {
+	content, err := json.Marshal(&callAssignedPayload{PairID: call.pair.id, Call: &call.pair.call})
+	if err != nil {
+		helper.logger().WithError(err).Error("couldn't encode the call assigned payload")
+		return false
+	}
+
+	state := h.helpers[helper]
+	state.assigned = call
+	state.assignedAt = time.Now()
+	call.pending++
+	if call.transfer {
+		call.pair.addTransferTarget(helper)
+	}
+	h.setHelperState(helper, state, helperStateBusy)
+
+	helper.logger().WithFields(log.Fields{
+		"pair_id":        call.pair.id,
+		"queue_wait":     time.Since(call.enqueuedAt).String(),
+		"caller_conn":    call.caller.id,
+		"priority":       call.pair.call.Priority,
+		"requirements":   call.requirements,
+		"skills":         state.skillList(),
+		"matched_skills": state.matchedSkills(call.requirements),
+		"relaxed":        call.relaxed,
+	}).Info("the call has been assigned to the helper")
+
+	helper.post(connectionMessage{Typ: outgoingMessageCallAssigned, Content: content})
+
+	return true
+}
+
+// notifyHelperAssigned tells the caller the call has been assigned
+func (h *hub) notifyHelperAssigned(call *queuedCall) {
+	content, err := json.Marshal(&helperAssignedPayload{PairID: call.pair.id})
+	if err != nil {
+		call.caller.logger().WithError(err).Error("couldn't encode the helper assigned payload")
+		return
+	}
+
+	call.caller.post(connectionMessage{Typ: outgoingMessageHelperAssigned, Content: content})
+}
+
+// releaseHelper puts the helper of the removed pair into the wrap-up state or makes them available right away,
+// the supervisors monitoring the pair are available right away
+func (h *hub) releaseHelper(p *pair) {
+	for c, state := range h.helpers {
+		if state.monitoring == p {
+			state.monitoring = nil
+			h.setHelperState(c, state, helperStateAvailable)
+			continue
+		}
+
+		if state.assigned != nil && state.assigned.pair == p {
+			h.releaseAssigned(c, state)
+		}
+	}
+
+	h.distribute()
+}
+
+// releaseAssigned releases the helper from the assigned call starting the wrap-up if it's configured
+func (h *hub) releaseAssigned(c *client, state *helperState) {
+	p := state.assigned.pair
+
+	// the calls the callers have left before the helper answered aren't counted
+	if p.isAnswered() {
+		h.recordHandleTime(time.Since(state.assignedAt))
+	}
+	state.assigned = nil
+	c.logger().WithField("pair_id", p.id).Debug("the helper has been released")
+
+	if wrapUp := h.queueOptions.WrapUp; wrapUp > 0 {
+		state.wrapUpEnds = time.Now().Add(wrapUp)
+		h.setHelperState(c, state, helperStateWrapUp)
+		return
+	}
+	h.setHelperState(c, state, helperStateAvailable)
+}
+
+// isWaitingForHelper reports whether the pair of the call assigned to the helper is still in the hub
+// and the helper hasn't joined it yet
+func (h *hub) isWaitingForHelper(helper *client, call *queuedCall) bool {
+	if _, ok := h.pairs[call.pair.id]; !ok {
+		return false
+	}
+
+	if call.transfer {
+		return call.pair.isTransferTarget(helper)
+	}
+
+	return !call.pair.isAnswered()
+}
+
+func (h *hub) updateQueueMetrics() {
+	metrics.QueuedCalls.Set(float64(len(h.queue)))
+}
@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestHub_matchHelper(t *testing.T) {
+	h := newHub(nil, nil, Options{})
+	busy := &client{}
+	recent := &client{}
+	idle := &client{}
+
+	h.helpers[busy] = &helperState{
+		state:    helperStateBusy,
+		since:    time.Now().Add(-time.Hour),
+		assigned: &queuedCall{},
+		skills:   map[string]bool{"de": true, "grief": true},
+	}
+	h.helpers[recent] = &helperState{
+		state:  helperStateAvailable,
+		since:  time.Now(),
+		skills: map[string]bool{"de": true},
+	}
+	h.helpers[idle] = &helperState{
+		state:  helperStateAvailable,
+		since:  time.Now().Add(-time.Minute),
+		skills: map[string]bool{"en": true},
+	}
+
+	if h.matchHelper(&queuedCall{}) != idle {
+		t.Error("the longest idle available helper expected for a call without requirements")
+	}
+
+	if h.matchHelper(&queuedCall{requirements: []string{"de"}}) != recent {
+		t.Error("the available helper with the required skill expected")
+	}
+
+	if h.matchHelper(&queuedCall{requirements: []string{"de", "grief"}}) != nil {
+		t.Error("no helper expected, the helper with all the required skills is busy")
+	}
+
+	if h.matchHelper(&queuedCall{requirements: []string{"de", "grief"}, relaxed: true}) != recent {
+		t.Error("the helper with the most of the required skills expected for the relaxed matching")
+	}
+
+	if h.matchHelper(&queuedCall{declinedBy: map[*client]bool{idle: true}}) != recent {
+		t.Error("the helper who has declined the call isn't expected to be offered it again")
+	}
+}
+
+func TestHub_assign_queued_call(t *testing.T) {
+	h := newTestHub(t, nil, Options{})
+	callerConn := h.connect("")
+	helperConn := h.connect("helper")
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"helper":true}`)})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+
+	msg, err := testExpectMessage(helperConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+
+	if _, err := testExpectMessage(callerConn, outgoingMessageHelperAssigned); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageAnswer, Content: assigned.PairID[:]})
+	if _, err := testExpectMessage(helperConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHub_anonymous_helper_rejected(t *testing.T) {
+	h := newTestHub(t, nil, Options{})
+	callerConn := h.connect("")
+	helperConn := h.connect("")
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"helper":true}`)})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+	if _, err := testExpectMessage(helperConn, outgoingMessageError); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(helperConn, outgoingMessageCallAssigned); err == nil {
+		t.Error("the call isn't expected to be assigned to an anonymous client")
+	}
+}
+
+func TestHub_queue_fallback(t *testing.T) {
+	// the status updates are disabled, the maximum wait is checked on its own
+	h := newTestHub(t, nil, Options{Queue: QueueOptions{MaxWait: time.Millisecond, Fallback: "Call 112"}})
+	callerConn := h.connect("")
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"helper":true}`)})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := testExpectMessage(callerConn, outgoingMessageQueueFallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fallback queueFallbackPayload
+	if err := json.Unmarshal(msg.Content, &fallback); err != nil {
+		t.Fatalf("couldn't decode the queue fallback: %s", err)
+	}
+	if fallback.Message != "Call 112" {
+		t.Errorf("the configured fallback message expected, got '%s'", fallback.Message)
+	}
+}
+
+func TestHub_helper_takes_calls_in_a_row(t *testing.T) {
+	h := newTestHub(t, nil, Options{})
+	helperConn := h.connect("helper")
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+
+	// the helper stays connected once the caller hangs up and takes the next queued call
+	for i := 0; i < 2; i++ {
+		callerConn, pairID := testAnswerQueuedCall(t, h, helperConn)
+		if err := callerConn.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := testExpectMessage(helperConn, outgoingMessageCallEnded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ended callEndedPayload
+		if err := json.Unmarshal(msg.Content, &ended); err != nil {
+			t.Fatalf("couldn't decode the call ended payload: %s", err)
+		}
+		if ended.PairID != pairID || ended.Reason != nil {
+			t.Errorf("the end of the call %s hung up by the caller expected, got %+v", pairID, ended)
+		}
+	}
+}
+
+// testAnswerQueuedCall connects a caller whose call the helper answers once it's assigned to them
+func testAnswerQueuedCall(t *testing.T, h *testHub, helperConn *webSocketConnStub) (*webSocketConnStub, uuid.UUID) {
+	callerConn := h.connect("")
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"helper":true}`)})
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := testExpectMessage(helperConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageAnswer, Content: assigned.PairID[:]})
+	if _, err := testExpectMessage(helperConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	return callerConn, assigned.PairID
+}
+
+func TestHub_wrapUp(t *testing.T) {
+	h := newTestHub(t, nil, Options{Queue: QueueOptions{WrapUp: time.Millisecond}})
+	helperConn := h.connect("helper")
+	testSendMessage(helperConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available"}`)})
+
+	callerConn, _ := testAnswerQueuedCall(t, h, helperConn)
+	if err := callerConn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the helper stays connected wrapping up the call the caller has hung up
+	testExpectHelperState(t, helperConn, helperStateWrapUp)
+
+	nextCallerConn := h.connect("")
+	testSendMessage(nextCallerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"helper":true}`)})
+	if _, err := testExpectMessage(nextCallerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next call is assigned once the wrap-up has ended
+	testExpectHelperState(t, helperConn, helperStateAvailable)
+	if _, err := testExpectMessage(helperConn, outgoingMessageCallAssigned); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testExpectHelperState waits for the helper to enter a given state skipping the other state changes
+func testExpectHelperState(t *testing.T, conn *webSocketConnStub, expected string) {
+	for {
+		msg, err := testExpectMessage(conn, outgoingMessageHelperState)
+		if err != nil {
+			t.Fatalf("the helper hasn't entered the %s state: %s", expected, err)
+		}
+
+		var state helperStatePayload
+		if err := json.Unmarshal(msg.Content, &state); err != nil {
+			t.Fatalf("couldn't decode the helper state: %s", err)
+		}
+		if state.State == expected {
+			return
+		}
+	}
+}
+
+func TestHub_estimatedWait(t *testing.T) {
+	h := newHub(nil, nil, Options{})
+
+	if _, ok := h.estimatedWait(1); ok {
+		t.Error("the wait isn't expected to be estimated without the handle times")
+	}
+
+	h.helpers[&client{}] = &helperState{}
+	h.helpers[&client{}] = &helperState{}
+	h.recordHandleTime(2 * time.Minute)
+	h.recordHandleTime(4 * time.Minute)
+
+	if wait, _ := h.estimatedWait(2); wait != 3*time.Minute {
+		t.Errorf("the average handle time expected for the position 2 with 2 helpers, got %v", wait)
+	}
+
+	if wait, _ := h.estimatedWait(3); wait != 6*time.Minute {
+		t.Errorf("two average handle times expected for the position 3 with 2 helpers, got %v", wait)
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxSkills      = 32
+	maxSkillLength = 64
+)
+
+// The period the queued calls are checked for the relaxation of the requirements, the expired offers
+// and the maximum wait, and the helpers are checked for the ended wrap-ups with
+const queueCheckInterval = time.Second
+
+// parseSkills normalizes the skill tags of a helper or the requirements of a call
+func parseSkills(tags []string) ([]string, error) {
+	if len(tags) > maxSkills {
+		return nil, errors.Errorf("at most %d skill tags are allowed", maxSkills)
+	}
+
+	skills := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxSkillLength {
+			return nil, errors.Errorf("the skill tags must be non-empty strings of at most %d bytes", maxSkillLength)
+		}
+		skills = append(skills, tag)
+	}
+
+	return skills, nil
+}
+
+// matchedSkills returns the number of the requirements the helper has the skills for
+func (s *helperState) matchedSkills(requirements []string) int {
+	matched := 0
+	for _, requirement := range requirements {
+		if s.skills[requirement] {
+			matched++
+		}
+	}
+
+	return matched
+}
+
+// matchHelper returns the longest idle available helper having all the skills the call requires
+// except the helpers the call has been offered to. The relaxed matching picks the helper having the most
+// of the required skills instead
+func (h *hub) matchHelper(call *queuedCall) *client {
+	var best *client
+	bestMatched := 0
+
+	// the supervisors take the escalated calls regardless of their skills
+	supervisorsOnly := call.escalated && h.hasSupervisor()
+
+	for c, state := range h.helpers {
+		if state.state != helperStateAvailable || call.declinedBy[c] || (supervisorsOnly && !h.isSupervisor(c)) {
+			continue
+		}
+
+		matched := state.matchedSkills(call.requirements)
+		if !call.relaxed && !supervisorsOnly && matched < len(call.requirements) {
+			continue
+		}
+
+		if best == nil || matched > bestMatched ||
+			(matched == bestMatched && state.since.Before(h.helpers[best].since)) {
+			best = c
+			bestMatched = matched
+		}
+	}
+
+	return best
+}
+
+// isRelaxed reports whether the call has waited long enough to be routed ignoring its requirements
+func (h *hub) isRelaxed(call *queuedCall) bool {
+	relaxAfter := h.queueOptions.RelaxAfter
+
+	return len(call.requirements) > 0 && relaxAfter > 0 && time.Since(call.enqueuedAt) >= relaxAfter
+}
+
+func (s *helperState) skillList() []string {
+	skills := make([]string, 0, len(s.skills))
+	for skill := range s.skills {
+		skills = append(skills, skill)
+	}
+
+	return skills
+}
@@ -1,59 +1,199 @@
 package handler
 
 import (
+	"context"
 	"net/http"
+	"regexp"
+	"sync/atomic"
 	"time"
 
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"bitbucket.org/stop-panic/signaling/internal/problem"
+	"bitbucket.org/stop-panic/signaling/internal/sdp"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	response "github.com/gromson/http-json-response"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	// Time allowed to read the next pong message from the peer.
 	pongWait = 60 * time.Second
+
+	// Header carrying the ID of the upgrade request, it's generated if the client hasn't sent a valid one
+	requestIDHeader = "X-Request-ID"
 )
 
+var tracer = otel.Tracer("bitbucket.org/stop-panic/signaling/internal/handler")
+
+// requestIDPattern matches the request IDs sent by the clients which are logged and echoed back
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Options configures the optional behaviour of the Server
+type Options struct {
+	// SubjectHeader is the name of a header set by a trusted proxy with the subject of the authenticated user,
+	// the anonymous clients can't register as helpers, register their presence nor request a callback
+	SubjectHeader string
+	// Supervisors are the subjects of the authenticated clients allowed to monitor the calls and barge in,
+	// no one could monitor the calls if it's empty
+	Supervisors  []string
+	RateLimits   RateLimits
+	MessageSizes MessageSizeLimits
+	Capacity     CapacityLimits
+	IceServers   IceServers
+	// RelayOnly strips the candidates revealing the addresses of the peers from the signaling of all the pairs
+	RelayOnly bool
+	// SdpPolicy validates the session descriptions of the signaling, the signaling isn't inspected if it's nil
+	SdpPolicy *sdp.Policy
+	Queue     QueueOptions
+	// HoldMessage is sent to the party put on hold, the held party is only told about the hold if it's empty
+	HoldMessage string
+}
+
 // Server serves web socket clients
 type Server struct {
 	upgrader  *websocket.Upgrader
 	hub       *hub
 	apiClient ApiClient
+	options   Options
+	limiter   *limiter
+	// connections is the capacity of the concurrent connections, the pairs capacity is counted by the hub
+	connections *capacity
+	draining    int32
 }
 
 // NewServer returns a pointer to a newly created Server instance
-func NewServer(upgrader *websocket.Upgrader, apiClient ApiClient) *Server {
-	h := newHub()
+func NewServer(upgrader *websocket.Upgrader, apiClient ApiClient, options Options) *Server {
+	pairCapacity := newCapacity(resourcePairs, options.Capacity.MaxPairs, options.Capacity.HighWatermark)
+	h := newHub(pairCapacity, apiClient, options)
 	go h.run()
 
 	return &Server{
-		upgrader:  upgrader,
-		hub:       h,
-		apiClient: apiClient,
+		upgrader:    upgrader,
+		hub:         h,
+		apiClient:   apiClient,
+		options:     options,
+		limiter:     newLimiter(options.RateLimits, options.MessageSizes),
+		connections: newCapacity(resourceConnections, options.Capacity.MaxConnections, options.Capacity.HighWatermark),
 	}
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if s.isDraining() {
+		problem.NewServiceUnavailable("the server is shutting down").Respond(w)
+		return
+	}
+
+	if !s.limiter.allowUpgrade(r.RemoteAddr) {
+		log.WithField("remote_addr", r.RemoteAddr).Warn("the connection upgrade is rejected by the rate limit")
+		problem.NewTooManyRequests("too many connection attempts").Respond(w)
+		return
+	}
+
+	// the pairs capacity is enforced on the call registration, so the callees could still answer the existing pairs
+	if !s.connections.tryAcquire() {
+		log.WithField("remote_addr", r.RemoteAddr).Warn("the connection upgrade is rejected, the server is at capacity")
+		w.Header().Set("Retry-After", retryAfterSeconds(s.options.Capacity.RetryAfter))
+		problem.NewServiceUnavailable("the server is at capacity").Respond(w)
+		return
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := tracer.Start(ctx, "websocket.upgrade", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	info := connectionInfo{
+		requestID:   requestID(r),
+		spanContext: span.SpanContext(),
+	}
+	if s.options.SubjectHeader != "" {
+		info.subject = r.Header.Get(s.options.SubjectHeader)
+	}
+
+	logger := log.WithFields(log.Fields{
+		"request_id":  info.requestID,
+		"remote_addr": r.RemoteAddr,
+		"subject":     info.subject,
+	})
+
+	// the header is echoed on the upgrade error responses as well as on the successful handshake
+	w.Header().Set(requestIDHeader, info.requestID)
+	responseHeader := http.Header{}
+	responseHeader.Set(requestIDHeader, info.requestID)
+
+	conn, err := s.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
-		log.WithError(err).Error("could not upgrade a connection")
+		logger.WithError(err).Error("could not upgrade a connection")
+		metrics.UpgradeFailures.Inc()
+		s.connections.release()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "could not upgrade a connection")
 		response.NewInternalError().Respond(w)
 		return
 	}
 
+	if s.limiter.maxMessageSize > 0 {
+		conn.SetReadLimit(s.limiter.maxMessageSize)
+	}
+
 	if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
-		log.WithError(err).Error("error while setting read deadline")
+		logger.WithError(err).Error("error while setting read deadline")
 	}
 
 	conn.SetPongHandler(
 		func(_ string) error {
 			if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
-				log.WithError(err).Error("error on trying to ping")
+				logger.WithError(err).Error("error on trying to ping")
 				return err
 			}
 			return nil
 		},
 	)
 
-	go newClient(conn, s.hub, s.apiClient).run()
+	go func() {
+		defer s.connections.release()
+		newClient(conn, s.hub, s.apiClient, s.limiter, &s.options.IceServers, info).run()
+	}()
+}
+
+// requestID returns the ID of the request sent by the client or a generated one if it's missing or invalid
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); requestIDPattern.MatchString(id) {
+		return id
+	}
+
+	return uuid.New().String()
+}
+
+// Drain makes the server reject new connections, the established ones are kept
+func (s *Server) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// CheckDraining reports an error if the server is draining
+func (s *Server) CheckDraining(_ context.Context) error {
+	if s.isDraining() {
+		return errors.New("the server is draining")
+	}
+
+	return nil
+}
+
+// Ping checks that the hub is responsive by passing a message through its loop
+func (s *Server) Ping(ctx context.Context) error {
+	select {
+	case s.hub.ping <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "the hub didn't respond")
+	}
 }
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(requestIDHeader, "req-1.a_B")
+	if id := requestID(r); id != "req-1.a_B" {
+		t.Errorf("the request ID of the client expected, got '%s'", id)
+	}
+
+	for _, invalid := range []string{"", "id with spaces", "id\nforged=entry", strings.Repeat("a", 65)} {
+		r.Header.Set(requestIDHeader, invalid)
+		if id := requestID(r); id == invalid || len(id) != 36 {
+			t.Errorf("a generated request ID expected instead of '%s', got '%s'", invalid, id)
+		}
+	}
+}
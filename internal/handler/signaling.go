@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// The types of the signaling messages relayed between the clients of a pair
+const (
+	signalingTypeOffer     = "offer"
+	signalingTypeAnswer    = "answer"
+	signalingTypeCandidate = "candidate"
+)
+
+// signalingMessage is the typed content of the signaling messages,
+// the descriptions and candidates follow the RTCSessionDescriptionInit and RTCIceCandidateInit dictionaries
+type signalingMessage struct {
+	Type      string        `json:"type"`
+	Sdp       string        `json:"sdp,omitempty"`
+	Candidate *iceCandidate `json:"candidate,omitempty"`
+	// fields keeps all the fields of the message, so the unknown ones are relayed untouched
+	fields map[string]json.RawMessage
+}
+
+type iceCandidate struct {
+	Candidate string `json:"candidate"`
+	// fields keeps all the fields of the candidate, so the unknown ones are relayed untouched
+	fields map[string]json.RawMessage
+}
+
+func (c *iceCandidate) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.fields); err != nil {
+		return err
+	}
+
+	if raw, ok := c.fields["candidate"]; ok {
+		return json.Unmarshal(raw, &c.Candidate)
+	}
+
+	return nil
+}
+
+func parseSignalingMessage(data []byte) (*signalingMessage, error) {
+	var msg signalingMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, errors.Wrap(err, "the signaling message is not a valid JSON")
+	}
+
+	if err := json.Unmarshal(data, &msg.fields); err != nil {
+		return nil, errors.Wrap(err, "the signaling message is not a JSON object")
+	}
+
+	switch msg.Type {
+	case signalingTypeOffer, signalingTypeAnswer:
+		if msg.Sdp == "" {
+			return nil, errors.Errorf("the %s signaling message has no sdp", msg.Type)
+		}
+	case signalingTypeCandidate:
+		if msg.Candidate == nil {
+			return nil, errors.New("the candidate signaling message has no candidate")
+		}
+	default:
+		return nil, errors.Errorf("unknown signaling message type: '%s'", msg.Type)
+	}
+
+	return &msg, nil
+}
+
+// Encode encodes the message with the modified sdp and candidate keeping the rest of the fields as they were received
+func (m *signalingMessage) Encode() ([]byte, error) {
+	fields := make(map[string]json.RawMessage, len(m.fields))
+	for key, value := range m.fields {
+		fields[key] = value
+	}
+
+	if m.Sdp != "" {
+		sdp, err := json.Marshal(m.Sdp)
+		if err != nil {
+			return nil, err
+		}
+		fields["sdp"] = sdp
+	}
+
+	if m.Candidate != nil {
+		candidateFields := make(map[string]json.RawMessage, len(m.Candidate.fields))
+		for key, value := range m.Candidate.fields {
+			candidateFields[key] = value
+		}
+
+		candidateLine, err := json.Marshal(m.Candidate.Candidate)
+		if err != nil {
+			return nil, err
+		}
+		candidateFields["candidate"] = candidateLine
+
+		candidate, err := json.Marshal(candidateFields)
+		if err != nil {
+			return nil, err
+		}
+		fields["candidate"] = candidate
+	}
+
+	return json.Marshal(fields)
+}
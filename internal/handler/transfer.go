@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"bitbucket.org/stop-panic/signaling/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The results of the warm transfers
+const (
+	transferCompleted = "completed"
+	transferFailed    = "failed"
+)
+
+// transfer is the pending warm transfer of an answered pair to another callee,
+// the transferring callee stays with the caller until one of the targets answers the pair
+type transfer struct {
+	// targets are the rung devices of the target user or the helper the transfer is assigned to
+	targets map[*client]bool
+}
+
+// transferRequest is sent to the hub by the callee of a pair handing the caller either to another user
+// or to the helpers having the required skills
+type transferRequest struct {
+	client       *client
+	pairID       uuid.UUID
+	userID       string
+	requirements []string
+}
+
+// transferCompletion is sent to the hub by a transferred pair releasing its previous callee
+type transferCompletion struct {
+	pair     *pair
+	previous *client
+}
+
+type transferRequestPayload struct {
+	UserID       string   `json:"user_id,omitempty"`
+	Requirements []string `json:"requirements,omitempty"`
+}
+
+// transferPayload is the content of the messages telling the caller and the previous callee about the completed transfer,
+// the caller renegotiates the session with the new callee
+type transferPayload struct {
+	PairID uuid.UUID `json:"pair_id"`
+}
+
+func parseTransferRequest(content []byte) (*transferRequest, error) {
+	var payload transferRequestPayload
+	if err := json.Unmarshal(content, &payload); err != nil {
+		return nil, errors.Wrap(err, "invalid transfer request")
+	}
+
+	if payload.UserID != "" && len(payload.Requirements) > 0 {
+		return nil, errors.New("a call can't be transferred both to a user and to the helpers")
+	}
+
+	if !utf8.ValidString(payload.UserID) || len(payload.UserID) > maxUserIDLength {
+		return nil, errors.Errorf("the user ID must be a valid UTF-8 string of at most %d bytes", maxUserIDLength)
+	}
+
+	requirements, err := parseSkills(payload.Requirements)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transferRequest{userID: payload.UserID, requirements: requirements}, nil
+}
+
+// transferCall starts the warm transfer of the pair ringing the online devices of the target user
+// or queueing the call for the helpers having the required skills
+func (h *hub) transferCall(request *transferRequest) {
+	c := request.client
+
+	p, err := h.findPair(request.pairID)
+	if err != nil || !p.isCallee(c) {
+		h.failTransfer(c, "Only the callee of an answered call could transfer it")
+		return
+	}
+
+	if !p.startTransfer() {
+		h.failTransfer(c, "The call is already being transferred")
+		return
+	}
+
+	c.logger().WithFields(log.Fields{
+		"pair_id":      p.id,
+		"target_user":  request.userID,
+		"requirements": request.requirements,
+	}).Info("the transfer of the call has been requested")
+
+	if request.userID == "" {
+		// the transferring callee gets the queue updates meant for the caller
+		h.enqueueCall(&queuedCall{
+			pair:         p,
+			caller:       c,
+			enqueuedAt:   time.Now(),
+			requirements: request.requirements,
+			transfer:     true,
+		})
+		return
+	}
+
+	devices := make([]*client, 0, len(h.users[request.userID]))
+	for device := range h.users[request.userID] {
+		if device != c {
+			devices = append(devices, device)
+		}
+	}
+
+	if len(devices) == 0 {
+		p.cancelTransfer()
+		h.failTransfer(c, "The transfer target is offline")
+		return
+	}
+
+	content, err := json.Marshal(&incomingCallPayload{PairID: p.id, Call: &p.call})
+	if err != nil {
+		c.logger().WithError(err).Error("couldn't encode the transfer offered payload")
+		p.cancelTransfer()
+		h.failTransfer(c, "Couldn't offer the transfer")
+		return
+	}
+	msg := connectionMessage{Typ: outgoingMessageTransferOffered, Content: content}
+
+	for _, device := range devices {
+		p.addTransferTarget(device)
+		device.post(msg)
+	}
+}
+
+// failTransfer reports the failed transfer to the client without waiting for it
+func (h *hub) failTransfer(c *client, desc string) {
+	metrics.CallTransfers.WithLabelValues(transferFailed).Inc()
+	go c.reportError(messageHandleError{Code: errorCodeTransfer, Desc: desc})
+}
+
+// isCallee reports whether the client has answered the pair
+func (p *pair) isCallee(c *client) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.clients[1] != nil && p.clients[1] == c
+}
+
+// startTransfer marks the pair as being transferred by its callee, it reports false if a transfer is already pending
+func (p *pair) startTransfer() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.transfer != nil {
+		return false
+	}
+	p.transfer = &transfer{targets: make(map[*client]bool)}
+
+	return true
+}
+
+func (p *pair) cancelTransfer() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.transfer = nil
+}
+
+func (p *pair) addTransferTarget(c *client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.transfer != nil {
+		p.transfer.targets[c] = true
+	}
+}
+
+func (p *pair) removeTransferTarget(c *client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.transfer != nil {
+		delete(p.transfer.targets, c)
+	}
+}
+
+// isTransferTarget reports whether the client could answer the pending transfer of the pair
+func (p *pair) isTransferTarget(c *client) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.transfer != nil && p.transfer.targets[c]
+}
+
+// completeTransfer replaces the callee of the pair with the target which has answered it ending the hold,
+// the caller renegotiates the session with the new callee and the previous callee is released staying connected
+func (p *pair) completeTransfer(c *client) {
+	// the caller put on hold while the transfer has been arranged talks to the new callee
+	p.endHold()
+
+	p.mu.Lock()
+	caller := p.clients[0]
+	previous := p.clients[1]
+	targets := p.transfer.targets
+	p.clients[1] = c
+	p.transfer = nil
+	p.mu.Unlock()
+
+	metrics.CallTransfers.WithLabelValues(transferCompleted).Inc()
+	c.logger().WithFields(log.Fields{
+		"pair_id":       p.id,
+		"previous_conn": previous.id,
+	}).Info("the call has been transferred")
+
+	// the clients are notified without blocking the pair, so it keeps relaying the signaling of the caller
+	go p.handOver(c, caller, previous, targets)
+}
+
+// handOver pairs the new callee, tells the caller and the previous callee about the completed transfer,
+// releases the previous callee in the hub and withdraws the transfer from the other targets
+func (p *pair) handOver(c, caller, previous *client, targets map[*client]bool) {
+	select {
+	case c.setPair <- p:
+	case <-c.terminate:
+	}
+
+	p.notifyTransferred(caller, previous)
+	previous.hub.transferred <- &transferCompletion{pair: p, previous: previous}
+
+	content, err := json.Marshal(&answeredElsewherePayload{PairID: p.id})
+	if err != nil {
+		log.WithError(err).WithField("pair_id", p.id).Error("couldn't encode the answered elsewhere payload")
+		return
+	}
+
+	for target := range targets {
+		if target != c {
+			target.post(connectionMessage{Typ: outgoingMessageAnsweredElsewhere, Content: content})
+		}
+	}
+}
+
+// notifyTransferred tells the caller to renegotiate the session and the previous callee that it has been released
+func (p *pair) notifyTransferred(caller, previous *client) {
+	content, err := json.Marshal(&transferPayload{PairID: p.id})
+	if err != nil {
+		log.WithError(err).WithField("pair_id", p.id).Error("couldn't encode the transfer payload")
+		return
+	}
+
+	if caller != nil {
+		caller.post(connectionMessage{Typ: outgoingMessageTransferred, Content: content})
+	}
+	previous.post(connectionMessage{Typ: outgoingMessageTransferCompleted, Content: content})
+}
+
+// releaseTransferred makes the helper who has transferred the pair available for the other calls
+func (h *hub) releaseTransferred(completion *transferCompletion) {
+	state, ok := h.helpers[completion.previous]
+	if !ok || state.assigned == nil || state.assigned.pair != completion.pair {
+		return
+	}
+
+	h.releaseAssigned(completion.previous, state)
+	h.distribute()
+}
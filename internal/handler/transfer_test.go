@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTransferRequest(t *testing.T) {
+	if _, err := parseTransferRequest([]byte(`{"user_id":"carol","requirements":["de"]}`)); err == nil {
+		t.Error("an error expected for a transfer both to a user and to the helpers")
+	}
+
+	request, err := parseTransferRequest([]byte(`{"requirements":[" DE "]}`))
+	if err != nil || len(request.requirements) != 1 || request.requirements[0] != "de" {
+		t.Errorf("the normalized requirements expected, got %+v, %v", request, err)
+	}
+}
+
+func TestPair_warm_transfer_to_user(t *testing.T) {
+	h := newTestHub(t, nil, Options{})
+	callerConn := h.connect("")
+	calleeConn := h.connect("bob")
+	targetConn := h.connect("carol")
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessagePresence, Content: []byte("bob")})
+	testSendMessage(targetConn, connectionMessage{Typ: incomingMessagePresence, Content: []byte("carol")})
+	if _, err := testExpectMessage(targetConn, outgoingMessagePresenceRegistered); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(callerConn, connectionMessage{Typ: incomingMessageCall, Content: []byte(`{"callee":"bob"}`)})
+
+	msg, err := testExpectMessage(calleeConn, outgoingMessageIncomingCall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var incoming incomingCallPayload
+	if err := json.Unmarshal(msg.Content, &incoming); err != nil {
+		t.Fatalf("couldn't decode the incoming call: %s", err)
+	}
+
+	if _, err := testExpectMessage(callerConn, outgoingMessageCallInitialized); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessageAnswer, Content: incoming.PairID[:]})
+	if _, err := testExpectMessage(calleeConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(calleeConn, connectionMessage{Typ: incomingMessageTransfer, Content: []byte(`{"user_id":"carol"}`)})
+	if _, err := testExpectMessage(targetConn, outgoingMessageTransferOffered); err != nil {
+		t.Fatal(err)
+	}
+
+	testSendMessage(targetConn, connectionMessage{Typ: incomingMessageAnswer, Content: incoming.PairID[:]})
+	if _, err := testExpectMessage(targetConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(callerConn, outgoingMessageTransferred); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(calleeConn, outgoingMessageTransferCompleted); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPair_warm_transfer_to_helpers(t *testing.T) {
+	h := newTestHub(t, nil, Options{Queue: QueueOptions{WrapUp: time.Minute}})
+	callerConn, firstConn, pairID := testAnswerHelperCall(t, h)
+	secondConn := h.connect("second")
+
+	testSendMessage(secondConn, connectionMessage{Typ: incomingMessageHelperStatus, Content: []byte(`{"status":"available","skills":["de"]}`)})
+	testSendMessage(firstConn, connectionMessage{Typ: incomingMessageTransfer, Content: []byte(`{"requirements":["de"]}`)})
+
+	msg, err := testExpectMessage(secondConn, outgoingMessageCallAssigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var assigned callAssignedPayload
+	if err := json.Unmarshal(msg.Content, &assigned); err != nil {
+		t.Fatalf("couldn't decode the call assignment: %s", err)
+	}
+	if assigned.PairID != pairID {
+		t.Fatalf("the transferred pair %s expected, got %s", pairID, assigned.PairID)
+	}
+
+	testSendMessage(secondConn, connectionMessage{Typ: incomingMessageAnswer, Content: pairID[:]})
+	if _, err := testExpectMessage(secondConn, outgoingMessageAnswerAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(callerConn, outgoingMessageTransferred); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := testExpectMessage(firstConn, outgoingMessageTransferCompleted); err != nil {
+		t.Fatal(err)
+	}
+
+	// the previous helper is released from the call staying connected
+	testExpectHelperState(t, firstConn, helperStateWrapUp)
+
+	testSendMessage(firstConn, connectionMessage{Typ: incomingMessageSignaling, Content: []byte(`{"type":"offer","sdp":"v=0"}`)})
+	msg, err = testExpectMessage(firstConn, outgoingMessageError)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handleErr messageHandleError
+	if err := json.Unmarshal(msg.Content, &handleErr); err != nil {
+		t.Fatalf("couldn't decode the error: %s", err)
+	}
+	if handleErr.Code != errorCodeSignaling {
+		t.Errorf("the signaling of the released helper expected to be rejected, got %+v", handleErr)
+	}
+}
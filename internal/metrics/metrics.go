@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "signaling"
+
+var (
+	// ConnectedClients is the number of WebSocket clients currently connected to the server
+	ConnectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_clients",
+		Help:      "Number of currently connected WebSocket clients.",
+	})
+
+	// ActivePairs is the number of pairs registered in the hub
+	ActivePairs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_pairs",
+		Help:      "Number of pairs registered in the hub.",
+	})
+
+	// PendingPairs is the number of pairs waiting for the second client to answer
+	PendingPairs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_pairs",
+		Help:      "Number of pairs with only one client connected.",
+	})
+
+	// IncomingMessages counts messages received from clients by message type
+	IncomingMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "incoming_messages_total",
+		Help:      "Number of messages received from clients by message type.",
+	}, []string{"type"})
+
+	// OutgoingMessages counts messages sent to clients by message type
+	OutgoingMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outgoing_messages_total",
+		Help:      "Number of messages sent to clients by message type.",
+	}, []string{"type"})
+
+	// MessageHandleErrors counts errors reported to clients by error code
+	MessageHandleErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "message_handle_errors_total",
+		Help:      "Number of errors reported to clients by error code.",
+	}, []string{"code"})
+
+	// ApiCallDuration observes the latency of the API calls
+	ApiCallDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_call_duration_seconds",
+		Help:      "Latency of the API call requests.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// ApiCallFailures counts failed API calls
+	ApiCallFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_call_failures_total",
+		Help:      "Number of failed API call requests.",
+	})
+
+	// RateLimited counts the requests rejected by the rate limits by the limit name
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Number of requests rejected by the rate limits.",
+	}, []string{"limit"})
+
+	// CapacityRejections counts the requests rejected because the capacity of a resource is exhausted
+	CapacityRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "capacity_rejections_total",
+		Help:      "Number of requests rejected because the capacity of a resource is exhausted.",
+	}, []string{"resource"})
+
+	// CapacityHighWatermark counts how many times the usage of a resource has risen above the high watermark
+	CapacityHighWatermark = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "capacity_high_watermark_total",
+		Help:      "Number of times the usage of a resource has risen above the high watermark.",
+	}, []string{"resource"})
+
+	// PrivacyRemovedCandidates counts the candidates removed from the signaling in the privacy mode
+	PrivacyRemovedCandidates = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "privacy_removed_candidates_total",
+		Help:      "Number of candidates removed from the signaling in the privacy mode.",
+	})
+
+	// OnlineUsers is the number of users registered in the presence registry
+	OnlineUsers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "online_users",
+		Help:      "Number of users registered in the presence registry.",
+	})
+
+	// CallNotifications counts the callees notified about the calls by the notification channel
+	CallNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "call_notifications_total",
+		Help:      "Number of callees notified about the calls by the notification channel.",
+	}, []string{"channel"})
+
+	// QueuedCalls is the number of calls waiting in the queue for an available helper
+	QueuedCalls = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queued_calls",
+		Help:      "Number of calls waiting in the queue for an available helper.",
+	})
+
+	// Helpers is the number of helpers by their state, e.g. available or on_break
+	Helpers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "helpers",
+		Help:      "Number of helpers by their state.",
+	}, []string{"state"})
+
+	// QueueWaitDuration observes the time the calls have waited in the queue before being assigned to a helper
+	QueueWaitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_wait_duration_seconds",
+		Help:      "Time the calls have waited in the queue before being assigned to a helper.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// OffersWithdrawn counts the calls offered to the helpers which have been withdrawn by the reason
+	OffersWithdrawn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "offers_withdrawn_total",
+		Help:      "Number of the calls offered to the helpers which have been declined or not answered in time.",
+	}, []string{"reason"})
+
+	// EscalatedCalls counts the calls escalated to the supervisors
+	EscalatedCalls = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "escalated_calls_total",
+		Help:      "Number of the calls escalated to the supervisors.",
+	})
+
+	// CallTransfers counts the warm transfers by their result, either completed or failed
+	CallTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "call_transfers_total",
+		Help:      "Number of the warm transfers of the calls by their result.",
+	}, []string{"result"})
+
+	// HoldDuration observes the time the calls have been held for
+	HoldDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "hold_duration_seconds",
+		Help:      "Time the calls have been held for.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// AbandonedWaitDuration observes the time the callers have waited for a helper before hanging up
+	AbandonedWaitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "abandoned_call_wait_duration_seconds",
+		Help:      "Time the abandoned calls have waited for a helper before the callers hung up.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// PendingCallbacks is the number of the callback requests no helper has claimed yet
+	PendingCallbacks = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_callbacks",
+		Help:      "Number of the callback requests waiting for a helper.",
+	})
+
+	// UpgradeFailures counts HTTP requests which couldn't be upgraded to the WebSocket protocol
+	UpgradeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upgrade_failures_total",
+		Help:      "Number of failed WebSocket upgrades.",
+	})
+)
+
+// Handler returns an HTTP handler exposing the registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
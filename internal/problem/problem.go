@@ -0,0 +1,29 @@
+package problem
+
+import (
+	"net/http"
+
+	response "github.com/gromson/http-json-response"
+)
+
+// NewServiceUnavailable returns a Problem response for the cases the server can't handle a request temporarily
+func NewServiceUnavailable(detail interface{}) *response.Problem {
+	p := response.NewInternalError()
+	p.Type = "https://tools.ietf.org/html/rfc7231#section-6.6.4"
+	p.Title = "Service Unavailable"
+	p.Status = http.StatusServiceUnavailable
+	p.Detail = detail
+
+	return p
+}
+
+// NewTooManyRequests returns a Problem response for the requests rejected by a rate limit
+func NewTooManyRequests(detail interface{}) *response.Problem {
+	p := response.NewInternalError()
+	p.Type = "https://tools.ietf.org/html/rfc6585#section-4"
+	p.Title = "Too Many Requests"
+	p.Status = http.StatusTooManyRequests
+	p.Detail = detail
+
+	return p
+}
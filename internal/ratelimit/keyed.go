@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Idle limiters are dropped after the period, the bucket of a returning key is full again by then anyway
+const sweepPeriod = 5 * time.Minute
+
+type entry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Keyed is a set of token bucket limiters, one per key (e.g. an IP address or a user)
+type Keyed struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// NewKeyed returns a pointer to a newly created Keyed instance allowing events at a given rate per second
+// with a given burst for every key. A nil limiter allowing everything is returned if the rate is not positive
+func NewKeyed(perSecond float64, burst int) *Keyed {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Keyed{
+		limit:     rate.Limit(perSecond),
+		burst:     burst,
+		entries:   make(map[string]*entry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow reports whether an event for a given key may happen now
+func (k *Keyed) Allow(key string) bool {
+	if k == nil {
+		return true
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	k.sweep(now)
+
+	e, ok := k.entries[key]
+	if !ok {
+		e = &entry{limiter: rate.NewLimiter(k.limit, k.burst)}
+		k.entries[key] = e
+	}
+	e.lastSeen = now
+
+	return e.limiter.AllowN(now, 1)
+}
+
+func (k *Keyed) sweep(now time.Time) {
+	if now.Sub(k.lastSweep) < sweepPeriod {
+		return
+	}
+
+	for key, e := range k.entries {
+		if now.Sub(e.lastSeen) >= sweepPeriod {
+			delete(k.entries, key)
+		}
+	}
+	k.lastSweep = now
+}
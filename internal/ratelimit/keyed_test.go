@@ -0,0 +1,31 @@
+package ratelimit
+
+import "testing"
+
+func TestKeyed_Allow_burst_per_key(t *testing.T) {
+	k := NewKeyed(0.001, 2)
+
+	for i := 0; i < 2; i++ {
+		if !k.Allow("first") {
+			t.Fatalf("event %d expected to be allowed within the burst", i+1)
+		}
+	}
+
+	if k.Allow("first") {
+		t.Error("event expected to be rejected after the burst is exhausted")
+	}
+
+	if !k.Allow("second") {
+		t.Error("the limit of one key expected not to affect another one")
+	}
+}
+
+func TestKeyed_Allow_disabled(t *testing.T) {
+	k := NewKeyed(0, 1)
+
+	for i := 0; i < 100; i++ {
+		if !k.Allow("key") {
+			t.Fatal("a disabled limiter expected to allow everything")
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoMedia is returned if the policy rejects all the media of a session description
+var ErrNoMedia = errors.New("no acceptable media left in the session description")
+
+// The names of the static RTP payload types (RFC 3551) which are usually not described with a=rtpmap
+var staticPayloadTypes = map[string]string{
+	"0":  "pcmu",
+	"3":  "gsm",
+	"8":  "pcma",
+	"9":  "g722",
+	"18": "g729",
+}
+
+// Policy restricts the media negotiated through the session descriptions
+type Policy struct {
+	// AllowedCodecs are the names of the allowed RTP codecs (case insensitive), all the codecs are allowed if empty.
+	// The retransmission is kept only if "rtx" is allowed together with the codec it's associated with
+	AllowedCodecs []string
+	// MaxBandwidth is the maximum bandwidth of a media section in kbps, it's not limited if zero
+	MaxBandwidth int
+	// AudioOnly rejects the video media sections
+	AudioOnly bool
+}
+
+// Apply modifies the session description to comply with the policy
+func (p *Policy) Apply(d *SessionDescription) error {
+	allowed := make(map[string]bool, len(p.AllowedCodecs))
+	for _, codec := range p.AllowedCodecs {
+		allowed[strings.ToLower(codec)] = true
+	}
+
+	if p.MaxBandwidth > 0 {
+		d.Session = capBandwidth(d.Session, p.MaxBandwidth)
+	}
+
+	accepted := 0
+	for _, m := range d.Media {
+		if m.IsRejected() {
+			continue
+		}
+
+		if p.AudioOnly && m.Type == "video" {
+			m.Reject()
+			continue
+		}
+
+		if len(allowed) > 0 && strings.Contains(m.Proto, "RTP") {
+			filterCodecs(m, allowed)
+			if m.IsRejected() {
+				continue
+			}
+		}
+
+		if p.MaxBandwidth > 0 {
+			m.Lines = limitMediaBandwidth(m.Lines, p.MaxBandwidth)
+		}
+
+		accepted++
+	}
+
+	if accepted == 0 {
+		return ErrNoMedia
+	}
+
+	return nil
+}
+
+// filterCodecs removes the formats of the codecs which are not allowed with their attributes,
+// the media is rejected if no format is left
+func filterCodecs(m *Media, allowed map[string]bool) {
+	codecs := make(map[string]string, len(m.Formats))
+	for _, format := range m.Formats {
+		codecs[format] = staticPayloadTypes[format]
+	}
+
+	for _, rtpmap := range m.Attribute("rtpmap") {
+		format, encoding, _ := strings.Cut(rtpmap, " ")
+		name, _, _ := strings.Cut(encoding, "/")
+		codecs[format] = strings.ToLower(name)
+	}
+
+	associated := make(map[string]string)
+	for _, fmtp := range m.Attribute("fmtp") {
+		format, params, _ := strings.Cut(fmtp, " ")
+		for _, param := range strings.Split(params, ";") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && key == "apt" {
+				associated[format] = value
+			}
+		}
+	}
+
+	kept := make(map[string]bool, len(m.Formats))
+	for _, format := range m.Formats {
+		if codecs[format] != "rtx" && allowed[codecs[format]] {
+			kept[format] = true
+		}
+	}
+	for _, format := range m.Formats {
+		if codecs[format] == "rtx" && allowed["rtx"] && kept[associated[format]] {
+			kept[format] = true
+		}
+	}
+
+	if len(kept) == 0 {
+		m.Reject()
+		return
+	}
+
+	formats := make([]string, 0, len(kept))
+	for _, format := range m.Formats {
+		if kept[format] {
+			formats = append(formats, format)
+		}
+	}
+	m.Formats = formats
+
+	lines := make([]Line, 0, len(m.Lines))
+	for _, line := range m.Lines {
+		if format, ok := formatOf(line); ok && !kept[format] {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	m.Lines = lines
+}
+
+// formatOf returns the format the attribute line describes if it's a format specific attribute
+func formatOf(line Line) (string, bool) {
+	if line.Type != 'a' {
+		return "", false
+	}
+
+	name, value, _ := strings.Cut(line.Value, ":")
+	switch name {
+	case "rtpmap", "fmtp", "rtcp-fb":
+		format, _, _ := strings.Cut(value, " ")
+		// the rtcp feedback could be declared for all the formats with a wildcard
+		return format, format != "*"
+	default:
+		return "", false
+	}
+}
+
+// limitMediaBandwidth caps the bandwidth lines of a media section adding one if there is none
+func limitMediaBandwidth(lines []Line, maxKbps int) []Line {
+	lines = capBandwidth(lines, maxKbps)
+
+	insertAt := 0
+	for i, line := range lines {
+		switch line.Type {
+		case 'b':
+			return lines
+		case 'i', 'c':
+			insertAt = i + 1
+		}
+	}
+
+	limit := Line{Type: 'b', Value: "AS:" + strconv.Itoa(maxKbps)}
+	lines = append(lines[:insertAt], append([]Line{limit}, lines[insertAt:]...)...)
+
+	return lines
+}
+
+// capBandwidth lowers the values of the AS (kbps) and TIAS (bps) bandwidth lines exceeding the limit
+func capBandwidth(lines []Line, maxKbps int) []Line {
+	for i, line := range lines {
+		if line.Type != 'b' {
+			continue
+		}
+
+		modifier, rawValue, _ := strings.Cut(line.Value, ":")
+		value, err := strconv.Atoi(rawValue)
+		if err != nil {
+			continue
+		}
+
+		limit := maxKbps
+		if modifier == "TIAS" {
+			limit = maxKbps * 1000
+		} else if modifier != "AS" {
+			continue
+		}
+
+		if value > limit {
+			lines[i].Value = modifier + ":" + strconv.Itoa(limit)
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,159 @@
+package sdp
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const lineBreak = "\r\n"
+
+// Line is a "<type>=<value>" line of a session description
+type Line struct {
+	Type  byte
+	Value string
+}
+
+func (l Line) String() string {
+	return string(l.Type) + "=" + l.Value
+}
+
+// SessionDescription is a session description (RFC 4566) split into the session level lines and the media sections
+type SessionDescription struct {
+	Session []Line
+	Media   []*Media
+}
+
+// Media is a media description starting with an "m=" line
+type Media struct {
+	Type    string
+	Port    string
+	Proto   string
+	Formats []string
+	// Lines are the lines of the section following the "m=" line
+	Lines []Line
+}
+
+// Parse parses and validates a session description
+func Parse(raw string) (*SessionDescription, error) {
+	desc := &SessionDescription{}
+	var media *Media
+	var seenOrigin, seenName, seenTiming bool
+
+	raw = strings.ReplaceAll(raw, lineBreak, "\n")
+	for i, rawLine := range strings.Split(strings.TrimRight(raw, "\n"), "\n") {
+		line, err := parseLine(rawLine)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", i+1)
+		}
+
+		if i == 0 {
+			if line.Type != 'v' || line.Value != "0" {
+				return nil, errors.New("the session description must start with the v=0 line")
+			}
+		}
+
+		switch {
+		case line.Type == 'm':
+			media, err = parseMedia(line.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", i+1)
+			}
+			desc.Media = append(desc.Media, media)
+			continue
+		case media != nil:
+			media.Lines = append(media.Lines, line)
+			continue
+		case line.Type == 'o':
+			if len(strings.Fields(line.Value)) != 6 {
+				return nil, errors.Errorf("line %d: the origin must have 6 fields", i+1)
+			}
+			seenOrigin = true
+		case line.Type == 's':
+			seenName = true
+		case line.Type == 't':
+			seenTiming = true
+		}
+
+		desc.Session = append(desc.Session, line)
+	}
+
+	if !seenOrigin || !seenName || !seenTiming {
+		return nil, errors.New("the session description must have the o=, s= and t= lines")
+	}
+
+	return desc, nil
+}
+
+func parseLine(raw string) (Line, error) {
+	if len(raw) < 2 || raw[1] != '=' || raw[0] < 'a' || raw[0] > 'z' {
+		return Line{}, errors.Errorf("malformed line: '%s'", raw)
+	}
+
+	return Line{Type: raw[0], Value: raw[2:]}, nil
+}
+
+func parseMedia(value string) (*Media, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		return nil, errors.Errorf("the media description must have at least 4 fields: '%s'", value)
+	}
+
+	port := strings.SplitN(fields[1], "/", 2)[0]
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, errors.Errorf("invalid media port: '%s'", fields[1])
+	}
+
+	return &Media{
+		Type:    fields[0],
+		Port:    fields[1],
+		Proto:   fields[2],
+		Formats: fields[3:],
+	}, nil
+}
+
+// Marshal encodes the session description
+func (d *SessionDescription) Marshal() string {
+	var b strings.Builder
+
+	for _, line := range d.Session {
+		b.WriteString(line.String() + lineBreak)
+	}
+
+	for _, m := range d.Media {
+		b.WriteString("m=" + strings.Join(append([]string{m.Type, m.Port, m.Proto}, m.Formats...), " ") + lineBreak)
+		for _, line := range m.Lines {
+			b.WriteString(line.String() + lineBreak)
+		}
+	}
+
+	return b.String()
+}
+
+// Attribute returns the values of the media attributes with a given name, e.g. "rtpmap" for "a=rtpmap:111 opus/48000/2"
+func (m *Media) Attribute(name string) []string {
+	var values []string
+	for _, line := range m.Lines {
+		if line.Type != 'a' {
+			continue
+		}
+
+		attrName, value, _ := strings.Cut(line.Value, ":")
+		if attrName == name {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// IsRejected reports whether the media section is disabled with the zero port
+func (m *Media) IsRejected() bool {
+	return m.Port == "0"
+}
+
+// Reject disables the media section, the formats are kept since the m= line must list at least one
+func (m *Media) Reject() {
+	m.Port = "0"
+}
@@ -0,0 +1,107 @@
+package sdp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const testOffer = "v=0\r\n" +
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=group:BUNDLE 0 1\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111 0\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"a=mid:0\r\n" +
+	"a=rtpmap:111 opus/48000/2\r\n" +
+	"a=fmtp:111 minptime=10;useinbandfec=1\r\n" +
+	"a=rtcp-fb:111 transport-cc\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98\r\n" +
+	"c=IN IP4 0.0.0.0\r\n" +
+	"b=AS:2500\r\n" +
+	"a=mid:1\r\n" +
+	"a=rtpmap:96 VP8/90000\r\n" +
+	"a=rtpmap:97 rtx/90000\r\n" +
+	"a=fmtp:97 apt=96\r\n" +
+	"a=rtpmap:98 H264/90000\r\n" +
+	"a=rtcp-fb:* nack\r\n"
+
+func TestParse_roundtrip(t *testing.T) {
+	desc, err := Parse(testOffer)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(desc.Media) != 2 || desc.Media[0].Type != "audio" || desc.Media[1].Type != "video" {
+		t.Fatalf("audio and video media expected, got %+v", desc.Media)
+	}
+
+	if desc.Marshal() != testOffer {
+		t.Errorf("the marshaled description differs from the parsed one:\n%s", desc.Marshal())
+	}
+}
+
+func TestParse_malformed(t *testing.T) {
+	descriptions := map[string]string{
+		"no version":   "o=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n",
+		"no origin":    "v=0\r\ns=-\r\nt=0 0\r\n",
+		"broken line":  "v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\nbroken\r\n",
+		"broken media": "v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\nm=audio port RTP/AVP 0\r\n",
+	}
+
+	for name, raw := range descriptions {
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("%s: an error expected", name)
+		}
+	}
+}
+
+func TestPolicy_allowed_codecs(t *testing.T) {
+	desc, _ := Parse(testOffer)
+	policy := &Policy{AllowedCodecs: []string{"opus", "vp8", "rtx"}}
+
+	if err := policy.Apply(desc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strings.Join(desc.Media[0].Formats, " ") != "111" {
+		t.Errorf("only opus expected in the audio, got %v", desc.Media[0].Formats)
+	}
+
+	if strings.Join(desc.Media[1].Formats, " ") != "96 97" {
+		t.Errorf("vp8 with its rtx expected in the video, got %v", desc.Media[1].Formats)
+	}
+
+	marshaled := desc.Marshal()
+	if strings.Contains(marshaled, "H264") || !strings.Contains(marshaled, "a=rtcp-fb:* nack") {
+		t.Errorf("unexpected attributes left:\n%s", marshaled)
+	}
+}
+
+func TestPolicy_audio_only_and_bandwidth(t *testing.T) {
+	desc, _ := Parse(testOffer)
+	policy := &Policy{AudioOnly: true, MaxBandwidth: 64}
+
+	if err := policy.Apply(desc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !desc.Media[1].IsRejected() {
+		t.Error("the video expected to be rejected")
+	}
+
+	if bandwidth := desc.Media[0].Lines[1]; bandwidth.String() != "b=AS:64" {
+		t.Errorf("the bandwidth limit expected after the connection line, got %s", bandwidth)
+	}
+}
+
+func TestPolicy_no_media(t *testing.T) {
+	desc, _ := Parse(testOffer)
+	policy := &Policy{AllowedCodecs: []string{"g729"}}
+
+	if err := policy.Apply(desc); !errors.Is(err, ErrNoMedia) {
+		t.Errorf("no media error expected, got %v", err)
+	}
+}
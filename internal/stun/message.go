@@ -0,0 +1,87 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// The subset of RFC 5389 needed to answer the Binding requests
+const (
+	headerSize  = 20
+	magicCookie = 0x2112A442
+
+	typeBindingRequest  = 0x0001
+	typeBindingResponse = 0x0101
+
+	attrXorMappedAddress = 0x0020
+
+	familyIPv4 = 0x01
+	familyIPv6 = 0x02
+)
+
+type transactionID [12]byte
+
+// parseBindingRequest returns the transaction ID of a Binding request
+func parseBindingRequest(data []byte) (transactionID, error) {
+	var id transactionID
+
+	if len(data) < headerSize {
+		return id, errors.New("the message is shorter than the STUN header")
+	}
+
+	// the two most significant bits of every STUN message are zeroes
+	if data[0]&0xC0 != 0 {
+		return id, errors.New("not a STUN message")
+	}
+
+	if binary.BigEndian.Uint32(data[4:8]) != magicCookie {
+		return id, errors.New("invalid magic cookie")
+	}
+
+	if binary.BigEndian.Uint16(data[0:2]) != typeBindingRequest {
+		return id, errors.New("not a Binding request")
+	}
+
+	if int(binary.BigEndian.Uint16(data[2:4])) != len(data)-headerSize {
+		return id, errors.New("the message length doesn't match the header")
+	}
+
+	copy(id[:], data[8:headerSize])
+
+	return id, nil
+}
+
+// newBindingResponse returns a Binding success response carrying the reflexive address of the client
+func newBindingResponse(id transactionID, addr *net.UDPAddr) []byte {
+	family := byte(familyIPv6)
+	ip := addr.IP.To16()
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		family = familyIPv4
+		ip = ip4
+	}
+
+	// the address is XORed with the magic cookie followed by the transaction ID
+	xorKey := make([]byte, 16)
+	binary.BigEndian.PutUint32(xorKey[0:4], magicCookie)
+	copy(xorKey[4:], id[:])
+
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port)^uint16(magicCookie>>16))
+	for i := range ip {
+		value[4+i] = ip[i] ^ xorKey[i]
+	}
+
+	msg := make([]byte, headerSize+4+len(value))
+	binary.BigEndian.PutUint16(msg[0:2], typeBindingResponse)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(4+len(value)))
+	binary.BigEndian.PutUint32(msg[4:8], magicCookie)
+	copy(msg[8:headerSize], id[:])
+	binary.BigEndian.PutUint16(msg[headerSize:headerSize+2], attrXorMappedAddress)
+	binary.BigEndian.PutUint16(msg[headerSize+2:headerSize+4], uint16(len(value)))
+	copy(msg[headerSize+4:], value)
+
+	return msg
+}
@@ -0,0 +1,79 @@
+package stun
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxMessageSize = 1500
+
+// Server answers the STUN Binding requests letting the clients discover their server reflexive addresses
+type Server struct {
+	mu   sync.Mutex
+	addr string
+	conn net.PacketConn
+}
+
+// NewServer returns a pointer to a newly created Server instance listening on a given UDP address
+func NewServer(addr string) *Server {
+	return &Server{addr: addr}
+}
+
+// ListenAndServe listens on the UDP address of the server and answers the requests until the server is closed
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't listen on %s", s.addr)
+	}
+
+	return s.Serve(conn)
+}
+
+// Serve answers the requests received through a given connection until the server is closed
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	buf := make([]byte, maxMessageSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return errors.Wrap(err, "error while reading a STUN request")
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		id, err := parseBindingRequest(buf[:n])
+		if err != nil {
+			log.WithError(err).WithField("remote_addr", addr.String()).Debug("invalid STUN request ignored")
+			continue
+		}
+
+		if _, err := conn.WriteTo(newBindingResponse(id, udpAddr), addr); err != nil {
+			log.WithError(err).WithField("remote_addr", addr.String()).Error("couldn't write a STUN response")
+		}
+	}
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.Close()
+}
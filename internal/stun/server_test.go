@@ -0,0 +1,76 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_binding(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("couldn't listen: %s", err)
+	}
+
+	server := NewServer("")
+	go func() { _ = server.Serve(conn) }()
+	defer server.Close()
+
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("couldn't dial: %s", err)
+	}
+	defer client.Close()
+
+	request := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(request[0:2], typeBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], magicCookie)
+	copy(request[8:], "transaction1")
+
+	if _, err := client.Write(request); err != nil {
+		t.Fatalf("couldn't send a request: %s", err)
+	}
+
+	if err := client.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("couldn't set a deadline: %s", err)
+	}
+
+	response := make([]byte, maxMessageSize)
+	n, err := client.Read(response)
+	if err != nil {
+		t.Fatalf("couldn't read a response: %s", err)
+	}
+	response = response[:n]
+
+	if binary.BigEndian.Uint16(response[0:2]) != typeBindingResponse {
+		t.Fatalf("binding response expected, got type %#x", binary.BigEndian.Uint16(response[0:2]))
+	}
+
+	if string(response[8:headerSize]) != "transaction1" {
+		t.Errorf("the transaction ID expected to be echoed, got %q", response[8:headerSize])
+	}
+
+	value := response[headerSize+4:]
+	port := binary.BigEndian.Uint16(value[2:4]) ^ uint16(magicCookie>>16)
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(value[4:8])^magicCookie)
+
+	local := client.LocalAddr().(*net.UDPAddr)
+	if !ip.Equal(local.IP) || int(port) != local.Port {
+		t.Errorf("reflexive address %s:%d expected, got %s:%d", local.IP, local.Port, ip, port)
+	}
+}
+
+func TestParseBindingRequest_invalid(t *testing.T) {
+	if _, err := parseBindingRequest([]byte{0x00, 0x01}); err == nil {
+		t.Error("an error expected for a truncated message")
+	}
+
+	request := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(request[0:2], typeBindingRequest)
+
+	if _, err := parseBindingRequest(request); err == nil {
+		t.Error("an error expected for a message without the magic cookie")
+	}
+}
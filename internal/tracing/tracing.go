@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+	ExporterOtlp   = "otlp"
+	ExporterStdout = "stdout"
+
+	serviceName = "signaling"
+)
+
+// Setup installs a global tracer provider exporting the spans with a given exporter.
+// Tracing is disabled if the exporter is empty, the returned function flushes the pending spans
+func Setup(exporter string, endpoint string) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if exporter == "" {
+		return func(_ context.Context) error { return nil }, nil
+	}
+
+	spanExporter, err := newExporter(exporter, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create a tracing resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func newExporter(exporter string, endpoint string) (sdktrace.SpanExporter, error) {
+	switch exporter {
+	case ExporterOtlp:
+		var options []otlptracehttp.Option
+		if endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+
+		spanExporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't create an otlp exporter")
+		}
+
+		return spanExporter, nil
+	case ExporterStdout:
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't create a stdout exporter")
+		}
+
+		return spanExporter, nil
+	default:
+		return nil, errors.Errorf("unknown tracing exporter: %s", exporter)
+	}
+}
@@ -0,0 +1,35 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+// Credentials are the time-limited TURN credentials as described in the TURN REST API draft
+// (draft-uberti-behave-turn-rest-00), the TURN server validates them with the same shared secret
+type Credentials struct {
+	Username string
+	Password string
+	Expires  time.Time
+}
+
+// NewCredentials returns the credentials of a given user valid for the ttl from now
+func NewCredentials(secret string, user string, ttl time.Duration, now time.Time) Credentials {
+	expires := now.Add(ttl)
+	username := strconv.FormatInt(expires.Unix(), 10)
+	if user != "" {
+		username += ":" + user
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+
+	return Credentials{
+		Username: username,
+		Password: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		Expires:  expires,
+	}
+}
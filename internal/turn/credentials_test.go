@@ -0,0 +1,39 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestNewCredentials(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	credentials := NewCredentials("secret", "alice", time.Hour, now)
+
+	if credentials.Username != "1600003600:alice" {
+		t.Errorf("unexpected username: %s", credentials.Username)
+	}
+
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte("1600003600:alice"))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if credentials.Password != expected {
+		t.Errorf("password %s expected, got %s", expected, credentials.Password)
+	}
+
+	if !credentials.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected expiry: %v", credentials.Expires)
+	}
+}
+
+func TestNewCredentials_without_user(t *testing.T) {
+	credentials := NewCredentials("secret", "", time.Minute, time.Unix(1600000000, 0))
+
+	if credentials.Username != "1600000060" {
+		t.Errorf("unexpected username: %s", credentials.Username)
+	}
+}